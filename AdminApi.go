@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// AdminApi exposes endpoints to manage the monitored gateways at runtime
+type AdminApi struct {
+	manager  *GatewayManager
+	token    string
+	reloader *Reloader
+}
+
+// NewAdminApi creates the admin api. Every request must send the token as bearer token,
+// with an empty token all requests are rejected.
+func NewAdminApi(manager *GatewayManager, token string) *AdminApi {
+	return &AdminApi{
+		manager: manager,
+		token:   token,
+	}
+}
+
+// SetReloader enables POST /-/reload to reload the config file
+func (a *AdminApi) SetReloader(reloader *Reloader) {
+	a.reloader = reloader
+}
+
+// Register adds the admin routes to the http service
+func (a *AdminApi) Register(httpService *HttpService) {
+	httpService.RegisterRoute("GET /admin/gateways", a.authorized(a.listGateways))
+	httpService.RegisterRoute("POST /admin/gateways", a.authorized(a.addGateway))
+	httpService.RegisterRoute("DELETE /admin/gateways/{id}", a.authorized(a.removeGateway))
+	httpService.RegisterRoute("POST /admin/gateways/{id}/pause", a.authorized(a.pauseGateway))
+	httpService.RegisterRoute("POST /admin/gateways/{id}/resume", a.authorized(a.resumeGateway))
+	if a.reloader != nil {
+		httpService.RegisterRoute("POST /-/reload", a.authorized(a.reloader.ServeHTTP))
+	}
+}
+
+func (a *AdminApi) authorized(handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expected := "Bearer " + a.token
+		if a.token == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	})
+}
+
+func (a *AdminApi) listGateways(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, a.manager.List())
+}
+
+func (a *AdminApi) addGateway(w http.ResponseWriter, r *http.Request) {
+	var gateway GatewayConfig
+	if err := json.NewDecoder(r.Body).Decode(&gateway); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if gateway.ID == "" && gateway.EUI == "" {
+		http.Error(w, "gateway_id or gateway_eui is required", http.StatusBadRequest)
+		return
+	}
+
+	gateway, err := a.manager.AddAtRuntime(gateway)
+	if err != nil {
+		writeManagerError(w, err)
+		return
+	}
+	writeJson(w, http.StatusCreated, gateway)
+}
+
+func (a *AdminApi) removeGateway(w http.ResponseWriter, r *http.Request) {
+	if err := a.manager.Remove(r.PathValue("id")); err != nil {
+		writeManagerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AdminApi) pauseGateway(w http.ResponseWriter, r *http.Request) {
+	if err := a.manager.Pause(r.PathValue("id")); err != nil {
+		writeManagerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AdminApi) resumeGateway(w http.ResponseWriter, r *http.Request) {
+	if err := a.manager.Resume(r.PathValue("id")); err != nil {
+		writeManagerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeManagerError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrGatewayNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrGatewayExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrInvalidGateway):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		slog.Error("Admin request failed", "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeJson(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		slog.Error("Failed to write response", "error", err)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminApi(t *testing.T) {
+	server := newMockStatsServer(t)
+	manager := NewGatewayManager(server.URL+"/", "/connection/stats", "test-key", time.Hour)
+	defer manager.StopAll()
+
+	httpService := NewHttpService()
+	NewAdminApi(manager, "secret").Register(httpService)
+
+	do := func(method string, path string, body string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		httpService.mux.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Missing token", func(t *testing.T) {
+		w := do("GET", "/admin/gateways", "", "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("Wrong token", func(t *testing.T) {
+		w := do("GET", "/admin/gateways", "", "wrong")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("Empty token", func(t *testing.T) {
+		httpService := NewHttpService()
+		NewAdminApi(manager, "").Register(httpService)
+		w := httptest.NewRecorder()
+		httpService.mux.ServeHTTP(w, httptest.NewRequest("GET", "/admin/gateways", nil))
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("Add gateway", func(t *testing.T) {
+		// Added paused so the test doesn't touch the global api call counters
+		w := do("POST", "/admin/gateways", `{"gateway_id": "gw-admin", "paused": true}`, "secret")
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		w = do("POST", "/admin/gateways", `{"gateway_id": "gw-admin"}`, "secret")
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("Add gateway with invalid body", func(t *testing.T) {
+		w := do("POST", "/admin/gateways", `{"gateway_id": ""}`, "secret")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = do("POST", "/admin/gateways", `not json`, "secret")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		w = do("POST", "/admin/gateways", `{"gateway_id": "My_Gateway"}`, "secret")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid gateway id")
+
+		w = do("POST", "/admin/gateways", `{"gateway_id": "gw-labels", "labels": {"unknown": "value"}}`, "secret")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+
+		// Without an identity server the id of an eui can't be looked up
+		w = do("POST", "/admin/gateways", `{"gateway_eui": "58A0CBFFFE800001"}`, "secret")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Resume and pause gateway", func(t *testing.T) {
+		w := do("POST", "/admin/gateways/gw-admin/resume", "", "secret")
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		w = do("GET", "/admin/gateways", "", "secret")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `[{"gateway_id": "gw-admin", "paused": false}]`, w.Body.String())
+
+		w = do("POST", "/admin/gateways/gw-admin/pause", "", "secret")
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		w = do("GET", "/admin/gateways", "", "secret")
+		assert.JSONEq(t, `[{"gateway_id": "gw-admin", "paused": true}]`, w.Body.String())
+
+		w = do("POST", "/admin/gateways/unknown/pause", "", "secret")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("Remove gateway", func(t *testing.T) {
+		w := do("DELETE", "/admin/gateways/gw-admin", "", "secret")
+		assert.Equal(t, http.StatusNoContent, w.Code)
+
+		w = do("DELETE", "/admin/gateways/gw-admin", "", "secret")
+		assert.Equal(t, http.StatusNotFound, w.Code)
+
+		w = do("GET", "/admin/gateways", "", "secret")
+		assert.JSONEq(t, `[]`, w.Body.String())
+	})
+
+	t.Run("Add gateway by eui returns its id", func(t *testing.T) {
+		identityServer, err := NewIdentityServer(newMockGatewayRegistry(t).URL)
+		assert.Nil(t, err)
+		manager.SetIdentityServer(identityServer)
+		defer manager.SetIdentityServer(nil)
+
+		w := do("POST", "/admin/gateways", `{"gateway_eui": "58-a0-cb-ff-fe-80-00-01", "paused": true}`, "secret")
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.JSONEq(t, `{"gateway_id": "gw-by-eui", "gateway_eui": "58A0CBFFFE800001", "paused": true}`, w.Body.String())
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// LoadApiKeyFile reads an API key from a file, e.g. a docker or kubernetes secret
+func LoadApiKeyFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading api key file: %w", err)
+	}
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return "", errors.New("api key file is empty")
+	}
+	return key, nil
+}
+
+// WatchApiKeyFile checks the file every interval and calls onChange when the key changed.
+// The file is read instead of watched for events, so keys mounted as kubernetes secrets
+// (which are replaced through symlinks) are detected as well.
+func WatchApiKeyFile(ctx context.Context, path string, currentKey string, interval time.Duration, onChange func(key string)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			key, err := LoadApiKeyFile(path)
+			if err != nil {
+				slog.Warn("Failed to reload the api key, keeping the current one", "path", path, "error", err)
+				continue
+			}
+			if key != currentKey {
+				currentKey = key
+				onChange(key)
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadApiKeyFile(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("Valid file", func(t *testing.T) {
+		path := filepath.Join(dir, "valid")
+		os.WriteFile(path, []byte("NNSXS.KEY.SECRET\n"), 0o600)
+
+		key, err := LoadApiKeyFile(path)
+		assert.Equal(t, "NNSXS.KEY.SECRET", key)
+		assert.Nil(t, err)
+	})
+
+	t.Run("Empty file", func(t *testing.T) {
+		path := filepath.Join(dir, "empty")
+		os.WriteFile(path, []byte(" \n"), 0o600)
+
+		_, err := LoadApiKeyFile(path)
+		assert.EqualError(t, err, "api key file is empty")
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		_, err := LoadApiKeyFile(filepath.Join(dir, "missing"))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
+
+func TestWatchApiKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(path, []byte("first"), 0o600)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan string, 10)
+	go WatchApiKeyFile(ctx, path, "first", 10*time.Millisecond, func(key string) {
+		changes <- key
+	})
+
+	// An empty file during a rotation keeps the current key
+	os.WriteFile(path, []byte(""), 0o600)
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, changes)
+
+	os.WriteFile(path, []byte("second\n"), 0o600)
+	select {
+	case key := <-changes:
+		assert.Equal(t, "second", key)
+	case <-time.After(time.Second):
+		t.Fatal("The changed key was not detected")
+	}
+}
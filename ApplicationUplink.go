@@ -0,0 +1,95 @@
+package main
+
+import (
+	"log/slog"
+	"maps"
+	"strconv"
+	"time"
+)
+
+// ApplicationUplink is an uplink message of a The Things Stack application, as sent by
+// webhooks and the MQTT integration
+type ApplicationUplink struct {
+	EndDeviceIds struct {
+		DeviceId       string `json:"device_id"`
+		ApplicationIds struct {
+			ApplicationId string `json:"application_id"`
+		} `json:"application_ids"`
+	} `json:"end_device_ids"`
+	UplinkMessage *struct {
+		RxMetadata []RxMetadata        `json:"rx_metadata"`
+		Locations  map[string]Location `json:"locations"`
+		Settings   struct {
+			DataRate struct {
+				Lora *struct {
+					Bandwidth       uint32 `json:"bandwidth"`
+					SpreadingFactor uint32 `json:"spreading_factor"`
+				} `json:"lora"`
+			} `json:"data_rate"`
+			Frequency Uint64String `json:"frequency"`
+		} `json:"settings"`
+	} `json:"uplink_message"`
+}
+
+// RxMetadata is the reception of an uplink by a gateway. Gateways of other networks
+// forwarded by Packet Broker have the gateway id packetbroker.
+type RxMetadata struct {
+	GatewayIds struct {
+		GatewayId string `json:"gateway_id"`
+	} `json:"gateway_ids"`
+	AntennaIndex int       `json:"antenna_index"`
+	Rssi         *float64  `json:"rssi"`
+	ChannelRssi  *float64  `json:"channel_rssi"`
+	Snr          *float64  `json:"snr"`
+	Location     *Location `json:"location"`
+}
+
+// device identifies the end device across applications
+func (uplink ApplicationUplink) device() string {
+	return uplink.EndDeviceIds.ApplicationIds.ApplicationId + "/" + uplink.EndDeviceIds.DeviceId
+}
+
+// observeUplink adds the reception of an uplink to the radio metrics of every gateway that received it.
+// Monitored gateways get the labels of their polled stats.
+func observeUplink(uplink ApplicationUplink) {
+	gatewayMetricsMu.RLock()
+	defer gatewayMetricsMu.RUnlock()
+
+	settings := uplink.UplinkMessage.Settings
+	device, hasLocation := uplink.deviceLocation()
+	for _, rx := range uplink.UplinkMessage.RxMetadata {
+		gatewayId := rx.GatewayIds.GatewayId
+		if gatewayId == "" {
+			continue
+		}
+
+		labels := monitoredGatewayLabels.labels(gatewayId)
+		uplinksReceived.With(labels).Inc()
+		heardDevices.add(uplink.device(), gatewayId, time.Now())
+
+		// Some gateways only report the RSSI of the channel
+		if rx.Rssi != nil {
+			uplinkRssi.With(labels).Observe(*rx.Rssi)
+		} else if rx.ChannelRssi != nil {
+			uplinkRssi.With(labels).Observe(*rx.ChannelRssi)
+		}
+		if rx.Snr != nil {
+			uplinkSnr.With(labels).Observe(*rx.Snr)
+		}
+		if lora := settings.DataRate.Lora; lora != nil {
+			dataRateLabels := maps.Clone(labels)
+			dataRateLabels["spreading_factor"] = strconv.FormatUint(uint64(lora.SpreadingFactor), 10)
+			dataRateLabels["bandwidth"] = strconv.FormatUint(uint64(lora.Bandwidth), 10)
+			uplinkDataRates.With(dataRateLabels).Inc()
+		}
+		if settings.Frequency.Valid {
+			frequencyLabels := maps.Clone(labels)
+			frequencyLabels["frequency"] = settings.Frequency.String()
+			uplinkFrequencies.With(frequencyLabels).Inc()
+		}
+		if hasLocation {
+			observeDistance(rx, device, labels)
+		}
+	}
+	slog.Debug("Received uplink", "device", uplink.device(), "gateways", len(uplink.UplinkMessage.RxMetadata))
+}
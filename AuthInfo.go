@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrApiKeyRejected = errors.New("the TTN API key was rejected, it is invalid or expired")
+	ErrMissingRights  = errors.New("the TTN API key is missing required rights")
+)
+
+var (
+	// Rights the exporter can't work without
+	requiredRights = []string{"RIGHT_GATEWAY_STATUS_READ"}
+	// Rights needed to read gateways from the Identity Server
+	identityServerRights = []string{"RIGHT_GATEWAY_INFO"}
+)
+
+// AuthInfo is the response of the auth_info endpoint of The Things Stack
+type AuthInfo struct {
+	ApiKey *struct {
+		ApiKey ApiKeyInfo `json:"api_key"`
+	} `json:"api_key"`
+	OAuthAccessToken *ApiKeyInfo `json:"oauth_access_token"`
+	UniversalRights  []string    `json:"universal_rights"`
+	IsAdmin          bool        `json:"is_admin"`
+}
+
+// ApiKeyInfo holds the rights and expiry of an API key or access token
+type ApiKeyInfo struct {
+	Rights    []string   `json:"rights"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (info AuthInfo) key() ApiKeyInfo {
+	switch {
+	case info.ApiKey != nil:
+		return info.ApiKey.ApiKey
+	case info.OAuthAccessToken != nil:
+		return *info.OAuthAccessToken
+	default:
+		return ApiKeyInfo{}
+	}
+}
+
+// Rights returns all rights granted to the key
+func (info AuthInfo) Rights() []string {
+	rights := append(slices.Clone(info.key().Rights), info.UniversalRights...)
+	slices.Sort(rights)
+	return slices.Compact(rights)
+}
+
+// ExpiresAt returns when the key expires, nil if it never does
+func (info AuthInfo) ExpiresAt() *time.Time {
+	return info.key().ExpiresAt
+}
+
+// HasRight checks if the key grants the right, either directly or through an *_ALL right
+func (info AuthInfo) HasRight(right string) bool {
+	rights := info.Rights()
+	if slices.Contains(rights, right) || slices.Contains(rights, "RIGHT_ALL") {
+		return true
+	}
+	// Keys of users and organizations grant the rights on their gateways
+	if strings.HasPrefix(right, "RIGHT_GATEWAY_") {
+		for _, all := range []string{"RIGHT_GATEWAY_ALL", "RIGHT_USER_ALL", "RIGHT_ORGANIZATION_ALL"} {
+			if slices.Contains(rights, all) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MissingRights returns the rights of the list the key doesn't grant
+func (info AuthInfo) MissingRights(rights []string) []string {
+	var missing []string
+	for _, right := range rights {
+		if !info.HasRight(right) {
+			missing = append(missing, right)
+		}
+	}
+	return missing
+}
+
+// AuthInfoChecker validates the rights and expiry of the API key
+type AuthInfoChecker struct {
+	apiService *TTNApiService
+}
+
+// NewAuthInfoChecker creates a checker for the API at apiRootUrl, e.g. https://eu1.cloud.thethings.network
+func NewAuthInfoChecker(apiRootUrl string, apiKey string) *AuthInfoChecker {
+	return &AuthInfoChecker{
+		apiService: NewTTNApiService(strings.TrimSuffix(apiRootUrl, "/")+"/api/v3/auth_info", apiKey),
+	}
+}
+
+// SetApiKey changes the key that is checked
+func (c *AuthInfoChecker) SetApiKey(apiKey string) {
+	c.apiService.SetApiToken(apiKey)
+}
+
+// Check fetches the auth info, updates the metrics and verifies the key
+func (c *AuthInfoChecker) Check() (AuthInfo, error) {
+	var info AuthInfo
+	err := c.apiService.GetJson(&info)
+
+	var statusError *StatusError
+	if errors.As(err, &statusError) && (statusError.StatusCode == http.StatusUnauthorized || statusError.StatusCode == http.StatusForbidden) {
+		return AuthInfo{}, fmt.Errorf("%w (%v)", ErrApiKeyRejected, err)
+	}
+	if err != nil {
+		return AuthInfo{}, fmt.Errorf("requesting auth info: %w", err)
+	}
+
+	apiKeyRights.Reset()
+	for _, right := range info.Rights() {
+		apiKeyRights.WithLabelValues(right).Set(1)
+	}
+	apiKeyExpiry.Reset()
+	if expiresAt := info.ExpiresAt(); expiresAt != nil {
+		apiKeyExpiry.WithLabelValues().Set(float64(expiresAt.Unix()))
+		if expiresAt.Before(time.Now()) {
+			return info, fmt.Errorf("%w (expired at %s)", ErrApiKeyRejected, expiresAt.Format(time.RFC3339))
+		}
+	}
+
+	if missing := info.MissingRights(requiredRights); len(missing) > 0 {
+		return info, fmt.Errorf("%w: %s", ErrMissingRights, strings.Join(missing, ", "))
+	}
+	if missing := info.MissingRights(identityServerRights); len(missing) > 0 {
+		slog.Warn("The TTN API key can't read gateways from the Identity Server", "missing_rights", missing)
+	}
+
+	return info, nil
+}
+
+// Run checks the key every interval until the context is done
+func (c *AuthInfoChecker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := c.Check(); err != nil {
+				slog.Error("The TTN API key check failed", "error", err)
+			}
+		}
+	}
+}
+
+// apiRootUrl returns the part of a TTN url before /api/v3
+func apiRootUrl(baseUrl string) string {
+	if i := strings.Index(baseUrl, "/api/v3"); i >= 0 {
+		return baseUrl[:i]
+	}
+	if u, err := url.Parse(baseUrl); err == nil && u.Host != "" {
+		return u.Scheme + "://" + u.Host
+	}
+	return baseUrl
+}
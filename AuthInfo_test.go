@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthInfo_HasRight(t *testing.T) {
+	tests := []struct {
+		name   string
+		rights []string
+		right  string
+		want   bool
+	}{
+		{"Direct right", []string{"RIGHT_GATEWAY_STATUS_READ"}, "RIGHT_GATEWAY_STATUS_READ", true},
+		{"Missing right", []string{"RIGHT_GATEWAY_INFO"}, "RIGHT_GATEWAY_STATUS_READ", false},
+		{"All gateway rights", []string{"RIGHT_GATEWAY_ALL"}, "RIGHT_GATEWAY_STATUS_READ", true},
+		{"All user rights", []string{"RIGHT_USER_ALL"}, "RIGHT_GATEWAY_INFO", true},
+		{"All rights", []string{"RIGHT_ALL"}, "RIGHT_GATEWAY_STATUS_READ", true},
+		{"All gateway rights don't grant application rights", []string{"RIGHT_GATEWAY_ALL"}, "RIGHT_APPLICATION_INFO", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := AuthInfo{OAuthAccessToken: &ApiKeyInfo{Rights: tt.rights}}
+			assert.Equal(t, tt.want, info.HasRight(tt.right))
+		})
+	}
+}
+
+func TestAuthInfoChecker_Check(t *testing.T) {
+	var status int
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v3/auth_info", r.URL.Path)
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	checker := NewAuthInfoChecker(server.URL+"/", "test-key")
+
+	t.Run("Valid key", func(t *testing.T) {
+		status = http.StatusOK
+		body = `{"api_key": {"api_key": {"rights": ["RIGHT_GATEWAY_STATUS_READ", "RIGHT_GATEWAY_INFO"], "expires_at": "2100-01-01T00:00:00Z"}}}`
+
+		info, err := checker.Check()
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"RIGHT_GATEWAY_INFO", "RIGHT_GATEWAY_STATUS_READ"}, info.Rights())
+		assert.Equal(t, 4102444800.0, testutil.ToFloat64(apiKeyExpiry.WithLabelValues()))
+		assert.Equal(t, 1.0, testutil.ToFloat64(apiKeyRights.WithLabelValues("RIGHT_GATEWAY_INFO")))
+	})
+
+	t.Run("Key without expiry", func(t *testing.T) {
+		status = http.StatusOK
+		body = `{"api_key": {"api_key": {"rights": ["RIGHT_GATEWAY_STATUS_READ"]}}}`
+
+		_, err := checker.Check()
+		assert.Nil(t, err)
+		assert.Equal(t, 0, testutil.CollectAndCount(apiKeyExpiry))
+		assert.Equal(t, 1, testutil.CollectAndCount(apiKeyRights))
+	})
+
+	t.Run("Missing rights", func(t *testing.T) {
+		status = http.StatusOK
+		body = `{"api_key": {"api_key": {"rights": ["RIGHT_GATEWAY_INFO"]}}}`
+
+		_, err := checker.Check()
+		assert.ErrorIs(t, err, ErrMissingRights)
+		assert.ErrorContains(t, err, "RIGHT_GATEWAY_STATUS_READ")
+	})
+
+	t.Run("Expired key", func(t *testing.T) {
+		status = http.StatusOK
+		body = `{"api_key": {"api_key": {"rights": ["RIGHT_GATEWAY_ALL"], "expires_at": "2020-01-01T00:00:00Z"}}}`
+
+		_, err := checker.Check()
+		assert.ErrorIs(t, err, ErrApiKeyRejected)
+		assert.Equal(t, 1577836800.0, testutil.ToFloat64(apiKeyExpiry.WithLabelValues()))
+	})
+
+	t.Run("Rejected key", func(t *testing.T) {
+		status = http.StatusUnauthorized
+		body = `{}`
+
+		_, err := checker.Check()
+		assert.ErrorIs(t, err, ErrApiKeyRejected)
+	})
+
+	t.Run("Server error", func(t *testing.T) {
+		status = http.StatusInternalServerError
+		body = `{}`
+
+		_, err := checker.Check()
+		assert.NotErrorIs(t, err, ErrApiKeyRejected)
+		assert.EqualError(t, err, "requesting auth info: unexpected status code: 500")
+	})
+}
+
+func TestApiRootUrl(t *testing.T) {
+	assert.Equal(t, "https://eu1.cloud.thethings.network", apiRootUrl("https://eu1.cloud.thethings.network/api/v3/gs/gateways/"))
+	assert.Equal(t, "http://localhost:1885", apiRootUrl("http://localhost:1885/some/path"))
+}
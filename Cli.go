@@ -0,0 +1,469 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/prometheus/exporter-toolkit/web"
+	"gopkg.in/yaml.v3"
+)
+
+const programName = "ttn-gateway-prometheus-exporter"
+
+// Set at build time with -ldflags "-X main.version=v1.2.3 -X main.commit=abc123"
+var (
+	version = "dev"
+	commit  = "unknown"
+)
+
+func init() {
+	// Fall back to the revision go build records from the repository
+	if info, ok := debug.ReadBuildInfo(); ok && commit == "unknown" {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				commit = setting.Value
+			}
+		}
+	}
+}
+
+func versionString() string {
+	return fmt.Sprintf("%s %s (commit %s, %s)", programName, version, commit, runtime.Version())
+}
+
+// envFlag is a command line flag that overrides an environment variable
+type envFlag struct {
+	env   string
+	kind  string // string, int or bool
+	usage string
+	value string
+	set   bool
+}
+
+func (f *envFlag) String() string {
+	return f.value
+}
+
+func (f *envFlag) Set(value string) error {
+	switch f.kind {
+	case "int":
+		if _, err := strconv.Atoi(value); err != nil {
+			return errors.New("must be a number")
+		}
+	case "bool":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return errors.New("must be true or false")
+		}
+	}
+	f.value = value
+	f.set = true
+	return nil
+}
+
+func (f *envFlag) IsBoolFlag() bool {
+	return f.kind == "bool"
+}
+
+// name returns the flag name of the env var, e.g. --ttn-api-key for TTN_API_KEY
+func (f *envFlag) name() string {
+	return strings.ToLower(strings.ReplaceAll(f.env, "_", "-"))
+}
+
+// newEnvFlags returns a flag for every environment variable of the exporter
+func newEnvFlags() []*envFlag {
+	return []*envFlag{
+		{env: "CONFIG_FILE", kind: "string", usage: "Path to a yaml config file"},
+		{env: "TTN_GATEWAY_ID", kind: "string", usage: "Comma separated ids of the gateways"},
+		{env: "TTN_GATEWAY_EUI", kind: "string", usage: "Comma separated EUIs of the gateways, looked up in the Identity Server"},
+		{env: "TTN_API_KEY", kind: "string", usage: "TTN API key"},
+		{env: "TTN_API_KEY_FILE", kind: "string", usage: "File to read the TTN API key from"},
+		{env: "TTN_BASE_URL", kind: "string", usage: "Base url of the gateway server API"},
+		{env: "TTN_URL_STATS_SUFFIX", kind: "string", usage: "Suffix of the stats url after the gateway id"},
+		{env: "TTN_TRANSPORT", kind: "string", usage: "API of the TTN to use: rest or grpc"},
+		{env: "TTN_GRPC_ADDRESS", kind: "string", usage: "host:port of the gRPC API, derived from the urls if empty"},
+		{env: "TTN_GRPC_INSECURE", kind: "bool", usage: "Connect to the TTN_GRPC_ADDRESS without TLS"},
+		{env: "READ_INTERVAL", kind: "int", usage: "Seconds between two polls of a gateway"},
+		{env: "AUTO_DETECT_CLUSTER", kind: "bool", usage: "Detect the cluster of gateways without a base url through the Identity Server"},
+		{env: "TTN_IDENTITY_SERVER_URL", kind: "string", usage: "Url of the Identity Server, derived from the base url if empty"},
+		{env: "CLUSTER_CACHE_TTL", kind: "int", usage: "Seconds the detected cluster of a gateway is cached"},
+		{env: "METADATA_TTL", kind: "int", usage: "Seconds the gateway metadata of the Identity Server is cached, 0 disables it"},
+		{env: "GATEWAY_LABELS", kind: "string", usage: "Comma separated name=value labels of all gateways"},
+		{env: "GATEWAY_ATTRIBUTE_LABELS", kind: "string", usage: "Comma separated label=attribute pairs to label the gateways with their attributes"},
+		{env: "AUTH_INFO_CHECK_INTERVAL", kind: "int", usage: "Seconds between two checks of the API key, 0 disables them"},
+		{env: "ADDRESS", kind: "string", usage: "Address the HTTP server listens on"},
+		{env: "WEB_CONFIG_FILE", kind: "string", usage: "Web config file to enable TLS and basic auth"},
+		{env: "ENABLE_RUNTIME_METRICS", kind: "bool", usage: "Export the Go runtime and process metrics"},
+		{env: "ENABLE_APP_METRICS", kind: "bool", usage: "Export the metrics of the exporter itself"},
+		{env: "LEGACY_METRIC_NAMES", kind: "bool", usage: "Also export the deprecated gw_rtt_* metrics"},
+		{env: "STRICT_API_SCHEMA", kind: "bool", usage: "Report fields of the TTN API the exporter doesn't know or misses"},
+		{env: "STREAM_EVENTS", kind: "bool", usage: "Subscribe to the events of the gateways for real time connection updates"},
+		{env: "ENABLE_WEBHOOKS", kind: "bool", usage: "Accept uplink webhooks of TTN applications to export the radio quality of the gateways"},
+		{env: "WEBHOOK_SECRET", kind: "string", usage: "Shared secret the webhooks must send"},
+		{env: "WEBHOOK_SECRET_HEADER", kind: "string", usage: "Header of the webhook secret"},
+		{env: "ENABLE_MQTT", kind: "bool", usage: "Subscribe to the uplinks of TTN applications in the MQTT integration"},
+		{env: "MQTT_URL", kind: "string", usage: "Url of the MQTT server, derived from the base url if empty"},
+		{env: "MQTT_API_KEY", kind: "string", usage: "API key of the applications without their own key"},
+		{env: "MQTT_APPLICATIONS", kind: "string", usage: "Comma separated ids of the applications, with an optional @tenant"},
+		{env: "DEVICE_REACH_WINDOW", kind: "int", usage: "Seconds an uplink counts towards the devices a gateway reaches"},
+		{env: "ENABLE_ADMIN_API", kind: "bool", usage: "Enable the admin API"},
+		{env: "ADMIN_API_TOKEN", kind: "string", usage: "Bearer token required by the admin API"},
+		{env: "PERSIST_GATEWAY_CHANGES", kind: "bool", usage: "Write gateway changes of the admin API to the config file"},
+		{env: "ENABLE_RELOAD_API", kind: "bool", usage: "Reload the config file on POST /-/reload without the admin API"},
+		{env: "LOG_LEVEL", kind: "string", usage: "Log level: debug, info, warn or error"},
+		{env: "LOG_FORMAT", kind: "string", usage: "Log format: text or json"},
+	}
+}
+
+// newFlagSet creates a flag set with all env flags
+func newFlagSet(name string, envFlags []*envFlag, output io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(output)
+	for _, f := range envFlags {
+		flags.Var(f, f.name(), fmt.Sprintf("%s (env %s)", f.usage, f.env))
+	}
+	return flags
+}
+
+const usage = `Usage: %[1]s [flags] [command] [command flags]
+
+Commands:
+  serve          Run the exporter (default)
+  check-config   Validate the configuration and exit
+  fetch <id>     Print the stats of a gateway once, by id or EUI
+  healthcheck    Check the health endpoint of a running exporter
+
+Flags override environment variables, which override the config file.
+
+Flags:
+`
+
+// run executes the command line and returns the exit code
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	envFlags := newEnvFlags()
+	flags := newFlagSet(programName, envFlags, stderr)
+	showVersion := flags.Bool("version", false, "Print the version and exit")
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, usage, programName)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return parseErrorCode(err)
+	}
+	if *showVersion {
+		fmt.Fprintln(stdout, versionString())
+		return 0
+	}
+
+	command, args := "serve", flags.Args()
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	// Every command accepts the env flags after its name as well
+	commandFlags := newFlagSet(programName+" "+command, envFlags, stderr)
+	var healthcheckUrl string
+	var healthcheckTimeout time.Duration
+	var healthcheckUsername, healthcheckPassword string
+	switch command {
+	case "serve", "check-config", "fetch":
+	case "healthcheck":
+		commandFlags.StringVar(&healthcheckUrl, "url", "", "Url of the health endpoint (default derived from the address and web config)")
+		commandFlags.DurationVar(&healthcheckTimeout, "timeout", 5*time.Second, "Timeout of the request")
+		commandFlags.StringVar(&healthcheckUsername, "username", "", "Basic auth user of the web config (env HEALTHCHECK_USERNAME)")
+		commandFlags.StringVar(&healthcheckPassword, "password", "", "Basic auth password of the web config (env HEALTHCHECK_PASSWORD)")
+	default:
+		fmt.Fprintf(stderr, "unknown command %q\n", command)
+		flags.Usage()
+		return 2
+	}
+	if err := commandFlags.Parse(args); err != nil {
+		return parseErrorCode(err)
+	}
+
+	for _, f := range envFlags {
+		if f.set {
+			os.Setenv(f.env, f.value)
+		}
+	}
+	// Existing variables win over the .env file, so flags do as well
+	godotenv.Load(".env")
+
+	configFile, config, err := loadConfig(stderr)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	switch command {
+	case "check-config":
+		err = checkConfigCommand(config, stdout)
+	case "fetch":
+		if commandFlags.NArg() != 1 {
+			fmt.Fprintln(stderr, "fetch needs exactly one gateway id or eui")
+			return 2
+		}
+		err = fetchCommand(config, commandFlags.Arg(0), stdout)
+	case "healthcheck":
+		if healthcheckUsername == "" {
+			healthcheckUsername = os.Getenv("HEALTHCHECK_USERNAME")
+		}
+		if healthcheckPassword == "" {
+			healthcheckPassword = os.Getenv("HEALTHCHECK_PASSWORD")
+		}
+		err = healthcheckCommand(config, healthcheckUrl, healthcheckTimeout, healthcheckUsername, healthcheckPassword)
+	default:
+		return serve(configFile, config)
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func parseErrorCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	return 2
+}
+
+// loadConfig reads the configuration and sets up the default logger
+func loadConfig(logOutput io.Writer) (string, *Config, error) {
+	configFile := getEnvString("CONFIG_FILE", "")
+	config, err := LoadConfig(configFile)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	logger, err := NewLogger(logOutput, config.LogLevel, config.LogFormat)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid logging configuration: %w", err)
+	}
+	slog.SetDefault(logger)
+	return configFile, config, nil
+}
+
+// checkConfig validates what serve needs at startup and returns the global API key
+func checkConfig(config *Config) (string, error) {
+	// The API key comes either from the env or from a file
+	apiKey := config.ApiKey
+	if config.ApiKeyFile != "" {
+		var err error
+		if apiKey, err = LoadApiKeyFile(config.ApiKeyFile); err != nil {
+			return "", fmt.Errorf("failed to load the TTN_API_KEY_FILE: %w", err)
+		}
+	}
+	if apiKey == "" && slices.ContainsFunc(config.Gateways, func(g GatewayConfig) bool { return g.ApiKey == "" && g.ApiKeyFile == "" }) {
+		return "", errors.New("the TTN_API_KEY is not configured")
+	}
+	if len(config.Gateways) == 0 && !config.EnableAdminApi {
+		return "", errors.New("the TTN_GATEWAY_ID is not configured")
+	}
+	if config.EnableAdminApi && config.AdminApiToken == "" {
+		return "", errors.New("the ADMIN_API_TOKEN is required for the admin API")
+	}
+	if config.EnableWebhooks && config.WebhookSecret == "" {
+		return "", errors.New("the WEBHOOK_SECRET is required for the webhooks")
+	}
+	if config.EnableMqtt {
+		if len(config.MqttApplications) == 0 {
+			return "", errors.New("the MQTT_APPLICATIONS are not configured")
+		}
+		for _, app := range config.MqttApplications {
+			if app.ApiKey == "" && config.MqttApiKey == "" {
+				return "", fmt.Errorf("mqtt application %q: no api key, set api_key or MQTT_API_KEY", app.username())
+			}
+		}
+		if mqttUrl(config) == "" {
+			return "", errors.New("the MQTT_URL is not configured")
+		}
+	}
+	if err := web.Validate(config.WebConfigFile); err != nil {
+		return "", fmt.Errorf("invalid web config file: %w", err)
+	}
+	if _, err := newIdentityServer(config); err != nil {
+		return "", err
+	}
+	return apiKey, nil
+}
+
+// newIdentityServer returns the Identity Server of the config, on the host of the base url by default
+func newIdentityServer(config *Config) (*IdentityServer, error) {
+	if config.IdentityServerUrl != "" {
+		return NewIdentityServer(config.IdentityServerUrl)
+	}
+	return NewIdentityServer(config.BaseUrl)
+}
+
+// newClusterResolver returns the resolver for the cluster detection, nil if it is disabled
+func newClusterResolver(identityServer *IdentityServer, config *Config) *ClusterResolver {
+	if !config.AutoDetectCluster {
+		return nil
+	}
+	return NewClusterResolver(identityServer, config.UrlStatsSuffix, time.Duration(config.ClusterCacheTtl)*time.Second)
+}
+
+// checkConfigCommand validates the configuration including the settings of every gateway
+func checkConfigCommand(config *Config, stdout io.Writer) error {
+	apiKey, err := checkConfig(config)
+	if err != nil {
+		return err
+	}
+
+	manager := NewGatewayManager(config.BaseUrl, config.UrlStatsSuffix, apiKey, time.Duration(config.ReadInterval)*time.Second)
+	var errs []error
+	for _, gateway := range config.Gateways {
+		if _, _, _, err := manager.resolve(gateway); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "The configuration is valid, %d gateway(s) configured\n", len(config.Gateways))
+	return nil
+}
+
+// fetchCommand prints the stats of a gateway once, using its settings if it is configured.
+// The gateway can be given by its id or EUI.
+func fetchCommand(config *Config, gateway string, stdout io.Writer) error {
+	apiKey := config.ApiKey
+	if config.ApiKeyFile != "" {
+		var err error
+		if apiKey, err = LoadApiKeyFile(config.ApiKeyFile); err != nil {
+			return fmt.Errorf("failed to load the TTN_API_KEY_FILE: %w", err)
+		}
+	}
+	identityServer, err := newIdentityServer(config)
+	if err != nil {
+		return err
+	}
+
+	gatewayConfig := GatewayConfig{ID: gateway}
+	if !gatewayIdPattern.MatchString(gateway) && euiPattern.MatchString(normalizeEUI(gateway)) {
+		gatewayConfig = GatewayConfig{EUI: normalizeEUI(gateway)}
+	}
+	if i := slices.IndexFunc(config.Gateways, func(g GatewayConfig) bool {
+		return g.ID == gateway || (g.EUI != "" && normalizeEUI(g.EUI) == normalizeEUI(gateway))
+	}); i >= 0 {
+		gatewayConfig = config.Gateways[i]
+	}
+	if err := validateGateway(gatewayConfig, nil); err != nil {
+		return err
+	}
+
+	manager := NewGatewayManager(config.BaseUrl, config.UrlStatsSuffix, apiKey, time.Duration(config.ReadInterval)*time.Second)
+	_, apiKey, _, err = manager.resolve(gatewayConfig)
+	if err != nil {
+		return err
+	}
+	if gatewayConfig.ID == "" {
+		if gatewayConfig.ID, err = identityServer.GatewayIdForEUI(normalizeEUI(gatewayConfig.EUI), apiKey); err != nil {
+			return fmt.Errorf("gateway eui %q: %w", gatewayConfig.EUI, err)
+		}
+	}
+	url, _, _, err := manager.resolve(gatewayConfig)
+	if err != nil {
+		return err
+	}
+	gatewayId := gatewayConfig.ID
+	if resolver := newClusterResolver(identityServer, config); resolver != nil && gatewayConfig.BaseUrl == "" {
+		if url, err = resolver.StatsUrl(gatewayId, apiKey); err != nil {
+			return fmt.Errorf("detecting the cluster of gateway %q: %w", gatewayId, err)
+		}
+	}
+
+	var stats json.RawMessage
+	if err := NewTTNApiService(url, apiKey).GetJson(&stats); err != nil {
+		return fmt.Errorf("fetching the stats of gateway %q: %w", gatewayId, err)
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, stats, "", "  "); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	_, err = out.WriteTo(stdout)
+	return err
+}
+
+// healthcheckCommand requests the health endpoint and fails unless it answers with 200
+func healthcheckCommand(config *Config, url string, timeout time.Duration, username string, password string) error {
+	client := &http.Client{Timeout: timeout}
+	if url == "" {
+		var err error
+		if url, err = localHealthUrl(config.Address, config.WebConfigFile); err != nil {
+			return err
+		}
+		// The certificate is usually not issued for localhost
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	// The basic auth of the web config also applies to the health endpoint
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("health check failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health check failed: %w", &StatusError{StatusCode: resp.StatusCode})
+	}
+	return nil
+}
+
+// localHealthUrl returns the url of the health endpoint of an exporter listening on address
+func localHealthUrl(address string, webConfigFile string) (string, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %q: %w", address, err)
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+
+	scheme := "http"
+	if webConfigFile != "" {
+		data, err := os.ReadFile(webConfigFile)
+		if err != nil {
+			return "", fmt.Errorf("reading web config file: %w", err)
+		}
+		var webConfig struct {
+			TLSServerConfig map[string]any `yaml:"tls_server_config"`
+		}
+		if err := yaml.Unmarshal(data, &webConfig); err != nil {
+			return "", fmt.Errorf("parsing web config file: %w", err)
+		}
+		if len(webConfig.TLSServerConfig) > 0 {
+			scheme = "https"
+		}
+	}
+	return scheme + "://" + net.JoinHostPort(host, port) + "/health", nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runCli runs the command line and restores the environment and logger afterwards
+func runCli(t *testing.T, args ...string) (int, string, string) {
+	for _, f := range newEnvFlags() {
+		t.Setenv(f.env, os.Getenv(f.env))
+	}
+	logger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(logger) })
+
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCli(t *testing.T) {
+	t.Run("Version", func(t *testing.T) {
+		code, stdout, _ := runCli(t, "--version")
+		assert.Equal(t, 0, code)
+		assert.Contains(t, stdout, programName+" dev")
+	})
+
+	t.Run("Help lists the commands and env flags", func(t *testing.T) {
+		code, _, stderr := runCli(t, "--help")
+		assert.Equal(t, 0, code)
+		assert.Contains(t, stderr, "check-config")
+		assert.Contains(t, stderr, "-ttn-api-key-file")
+		assert.Contains(t, stderr, "(env TTN_API_KEY_FILE)")
+	})
+
+	t.Run("Unknown command", func(t *testing.T) {
+		code, _, stderr := runCli(t, "unknown")
+		assert.Equal(t, 2, code)
+		assert.Contains(t, stderr, `unknown command "unknown"`)
+	})
+
+	t.Run("Invalid flag value", func(t *testing.T) {
+		code, _, stderr := runCli(t, "--read-interval", "often", "check-config")
+		assert.Equal(t, 2, code)
+		assert.Contains(t, stderr, "must be a number")
+	})
+}
+
+func TestCli_CheckConfig(t *testing.T) {
+	path := writeConfigFile(t, `
+api_key: test-key
+read_interval: 300
+gateways:
+  - id: gw-1
+  - id: gw-2
+    api_key_file: /does/not/exist
+`)
+
+	t.Run("Invalid gateway", func(t *testing.T) {
+		code, _, stderr := runCli(t, "--config-file", path, "check-config")
+		assert.Equal(t, 1, code)
+		assert.Contains(t, stderr, `gateway "gw-2"`)
+	})
+
+	t.Run("Valid", func(t *testing.T) {
+		valid := writeConfigFile(t, "api_key: test-key\ngateways:\n  - id: gw-1\n")
+		code, stdout, _ := runCli(t, "--config-file", valid, "--ttn-gateway-id", "gw-3", "check-config")
+		assert.Equal(t, 0, code)
+		assert.Contains(t, stdout, "2 gateway(s)")
+	})
+
+	t.Run("Flags after the command override the file", func(t *testing.T) {
+		valid := writeConfigFile(t, "api_key: test-key\nread_interval: 300\ngateways:\n  - id: gw-1\n")
+		code, _, stderr := runCli(t, "check-config", "--config-file", valid, "--read-interval", "0")
+		assert.Equal(t, 1, code)
+		assert.Contains(t, stderr, "the read interval must be greater than 0")
+	})
+
+	t.Run("Admin API without token", func(t *testing.T) {
+		code, _, stderr := runCli(t, "--enable-admin-api", "--ttn-api-key", "test-key", "check-config")
+		assert.Equal(t, 1, code)
+		assert.Contains(t, stderr, "the ADMIN_API_TOKEN is required for the admin API")
+	})
+
+	t.Run("Webhooks without secret", func(t *testing.T) {
+		valid := writeConfigFile(t, "api_key: test-key\ngateways:\n  - id: gw-1\n")
+		code, _, stderr := runCli(t, "--config-file", valid, "--enable-webhooks", "check-config")
+		assert.Equal(t, 1, code)
+		assert.Contains(t, stderr, "the WEBHOOK_SECRET is required for the webhooks")
+	})
+}
+
+func TestCli_Fetch(t *testing.T) {
+	server := newMockStatsServer(t)
+
+	code, stdout, _ := runCli(t, "--ttn-api-key", "test-key", "--ttn-base-url", server.URL+"/", "fetch", "gw-fetch")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stdout, `"uplink_count": "42"`)
+
+	code, _, stderr := runCli(t, "--ttn-api-key", "test-key", "fetch")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "exactly one gateway id")
+}
+
+func TestCli_Healthcheck(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	code, _, _ := runCli(t, "--enable-admin-api", "healthcheck", "--url", server.URL+"/health")
+	assert.Equal(t, 0, code)
+
+	status = http.StatusServiceUnavailable
+	code, _, stderr := runCli(t, "--enable-admin-api", "healthcheck", "--url", server.URL+"/health")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "unexpected status code: 503")
+}
+
+func TestCli_HealthcheckBasicAuth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "prometheus" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	code, _, stderr := runCli(t, "healthcheck", "--url", server.URL+"/health")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "unexpected status code: 401")
+
+	code, _, _ = runCli(t, "healthcheck", "--url", server.URL+"/health", "--username", "prometheus", "--password", "secret")
+	assert.Equal(t, 0, code)
+
+	t.Setenv("HEALTHCHECK_USERNAME", "prometheus")
+	t.Setenv("HEALTHCHECK_PASSWORD", "secret")
+	code, _, _ = runCli(t, "healthcheck", "--url", server.URL+"/health")
+	assert.Equal(t, 0, code)
+}
+
+func TestLocalHealthUrl(t *testing.T) {
+	tlsConfig := filepath.Join(t.TempDir(), "web-config.yml")
+	assert.Nil(t, os.WriteFile(tlsConfig, []byte("tls_server_config:\n  cert_file: server.crt\n  key_file: server.key\n"), 0o600))
+	authConfig := filepath.Join(t.TempDir(), "web-config.yml")
+	assert.Nil(t, os.WriteFile(authConfig, []byte("basic_auth_users:\n  dave: hash\n"), 0o600))
+
+	tests := []struct {
+		address       string
+		webConfigFile string
+		want          string
+	}{
+		{":9000", "", "http://localhost:9000/health"},
+		{"0.0.0.0:9000", "", "http://localhost:9000/health"},
+		{"[::]:9000", "", "http://localhost:9000/health"},
+		{"127.0.0.1:2112", "", "http://127.0.0.1:2112/health"},
+		{":9000", tlsConfig, "https://localhost:9000/health"},
+		{":9000", authConfig, "http://localhost:9000/health"},
+	}
+	for _, tt := range tests {
+		got, err := localHealthUrl(tt.address, tt.webConfigFile)
+		assert.Nil(t, err)
+		assert.Equal(t, tt.want, got)
+	}
+
+	_, err := localHealthUrl("9000", "")
+	assert.NotNil(t, err)
+}
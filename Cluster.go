@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ttnCloudDomain = ".cloud.thethings.network"
+	ttiCloudDomain = ".cloud.thethings.industries"
+)
+
+// gatewayServerUrl returns the url of the gateway server API. A plain cluster
+// url like https://nam1.cloud.thethings.network gets the default API path.
+func gatewayServerUrl(baseUrl string) string {
+	if baseUrl == "" || strings.Contains(baseUrl, "/api/v3") {
+		return baseUrl
+	}
+	return strings.TrimSuffix(baseUrl, "/") + "/api/v3/gs/gateways/"
+}
+
+// clusterAndTenant derives the cluster and tenant from the host of a The Things Stack url:
+// eu1.cloud.thethings.network is cluster eu1, my-tenant.nam1.cloud.thethings.industries
+// is tenant my-tenant on cluster nam1. Other hosts are their own cluster without a tenant.
+func clusterAndTenant(rawUrl string) (cluster string, tenant string) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", ""
+	}
+	host := u.Hostname()
+
+	switch {
+	case strings.HasSuffix(host, ttnCloudDomain):
+		parts := strings.Split(strings.TrimSuffix(host, ttnCloudDomain), ".")
+		return parts[len(parts)-1], ""
+	case strings.HasSuffix(host, ttiCloudDomain):
+		parts := strings.Split(strings.TrimSuffix(host, ttiCloudDomain), ".")
+		if len(parts) > 1 {
+			tenant = parts[0]
+		}
+		return parts[len(parts)-1], tenant
+	default:
+		return host, ""
+	}
+}
+
+// ClusterResolver finds the gateway server a gateway is connected to through the
+// gateway_server_address of the gateway in the Identity Server
+type ClusterResolver struct {
+	mu             sync.Mutex
+	identityServer *IdentityServer
+	urlSuffix      string
+	ttl            time.Duration
+	cache          map[string]clusterCacheEntry
+}
+
+type clusterCacheEntry struct {
+	address string
+	expires time.Time
+}
+
+// NewClusterResolver creates a resolver using the Identity Server. Addresses are cached for ttl.
+func NewClusterResolver(identityServer *IdentityServer, urlSuffix string, ttl time.Duration) *ClusterResolver {
+	return &ClusterResolver{
+		identityServer: identityServer,
+		urlSuffix:      urlSuffix,
+		ttl:            ttl,
+		cache:          make(map[string]clusterCacheEntry),
+	}
+}
+
+// StatsUrl returns the url of the stats of the gateway on the gateway server it is connected to
+func (r *ClusterResolver) StatsUrl(gatewayId string, apiKey string) (string, error) {
+	address, err := r.gatewayServerAddress(gatewayId, apiKey)
+	if err != nil {
+		return "", err
+	}
+	// The address is the one of the gateway, the API is served on the web port like the Identity Server
+	isUrl := r.identityServer.url
+	u := url.URL{Scheme: isUrl.Scheme, Host: address}
+	if port := isUrl.Port(); port != "" {
+		u.Host = net.JoinHostPort(address, port)
+	}
+	return gatewayServerUrl(u.String()) + gatewayId + r.urlSuffix, nil
+}
+
+// Invalidate drops the cached address of a gateway, e.g. after the gateway server didn't know it
+func (r *ClusterResolver) Invalidate(gatewayId string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.cache, gatewayId)
+}
+
+func (r *ClusterResolver) gatewayServerAddress(gatewayId string, apiKey string) (string, error) {
+	r.mu.Lock()
+	entry, ok := r.cache[gatewayId]
+	r.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.address, nil
+	}
+
+	address, err := r.identityServer.GatewayServerAddress(gatewayId, apiKey)
+	if err != nil {
+		return "", err
+	}
+	address = gatewayServerHost(address)
+	if address == "" {
+		return "", errors.New("the gateway has no gateway server address")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cache[gatewayId] = clusterCacheEntry{address: address, expires: time.Now().Add(r.ttl)}
+	return address, nil
+}
+
+// gatewayServerHost returns the host of a gateway server address, which can
+// have a scheme and the port of the packet forwarder, e.g. eu1.cloud.thethings.network:1700
+func gatewayServerHost(address string) string {
+	if strings.Contains(address, "://") {
+		if u, err := url.Parse(address); err == nil {
+			return u.Hostname()
+		}
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGatewayServerUrl(t *testing.T) {
+	tests := []struct {
+		baseUrl string
+		want    string
+	}{
+		{"", ""},
+		{"https://nam1.cloud.thethings.network", "https://nam1.cloud.thethings.network/api/v3/gs/gateways/"},
+		{"https://nam1.cloud.thethings.network/", "https://nam1.cloud.thethings.network/api/v3/gs/gateways/"},
+		{"https://eu1.cloud.thethings.network/api/v3/gs/gateways/", "https://eu1.cloud.thethings.network/api/v3/gs/gateways/"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, gatewayServerUrl(tt.baseUrl), tt.baseUrl)
+	}
+}
+
+func TestClusterAndTenant(t *testing.T) {
+	tests := []struct {
+		url     string
+		cluster string
+		tenant  string
+	}{
+		{"https://eu1.cloud.thethings.network/api/v3/gs/gateways/gw/connection/stats", "eu1", ""},
+		{"https://au1.cloud.thethings.network", "au1", ""},
+		{"https://acme.nam1.cloud.thethings.industries/api/v3/gs/gateways/", "nam1", "acme"},
+		{"https://eu1.cloud.thethings.industries/api/v3/gs/gateways/", "eu1", ""},
+		{"https://lns.example.com:8885/api/v3/gs/gateways/", "lns.example.com", ""},
+		{"://invalid", "", ""},
+	}
+	for _, tt := range tests {
+		cluster, tenant := clusterAndTenant(tt.url)
+		assert.Equal(t, tt.cluster, cluster, tt.url)
+		assert.Equal(t, tt.tenant, tenant, tt.url)
+	}
+}
+
+func TestGatewayMetricLabels(t *testing.T) {
+	url := "https://acme.eu1.cloud.thethings.industries/api/v3/gs/gateways/gw/connection/stats"
+
+	labels := gatewayMetricLabels(GatewayConfig{ID: "gw"}, url, nil)
+	assert.Equal(t, "eu1", labels["cluster"])
+	assert.Equal(t, "acme", labels["tenant"])
+
+	labels = gatewayMetricLabels(GatewayConfig{ID: "gw", Cluster: "private", Tenant: "other"}, url, nil)
+	assert.Equal(t, "private", labels["cluster"])
+	assert.Equal(t, "other", labels["tenant"])
+}
+
+func newMockIdentityServer(t *testing.T, address *string, lookups *int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v3/gateways/gw-cluster", r.URL.Path)
+		assert.Equal(t, "gateway_server_address", r.URL.Query().Get("field_mask"))
+		assert.Equal(t, "Bearer test-key", r.Header.Get("Authorization"))
+		*lookups++
+		json.NewEncoder(w).Encode(map[string]string{"gateway_server_address": *address})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClusterResolver(t *testing.T) {
+	address, lookups := "127.0.0.1", 0
+	server := newMockIdentityServer(t, &address, &lookups)
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+
+	identityServer, err := NewIdentityServer(server.URL)
+	assert.Nil(t, err)
+	resolver := NewClusterResolver(identityServer, "/connection/stats", time.Hour)
+
+	t.Run("Lookup is cached", func(t *testing.T) {
+		for range 2 {
+			url, err := resolver.StatsUrl("gw-cluster", "test-key")
+			assert.Nil(t, err)
+			assert.Equal(t, fmt.Sprintf("http://127.0.0.1:%d/api/v3/gs/gateways/gw-cluster/connection/stats", port), url)
+		}
+		assert.Equal(t, 1, lookups)
+	})
+
+	t.Run("Moved gateway after invalidating", func(t *testing.T) {
+		address = "localhost:1700"
+		resolver.Invalidate("gw-cluster")
+
+		url, err := resolver.StatsUrl("gw-cluster", "test-key")
+		assert.Nil(t, err)
+		assert.Equal(t, fmt.Sprintf("http://localhost:%d/api/v3/gs/gateways/gw-cluster/connection/stats", port), url)
+		assert.Equal(t, 2, lookups)
+	})
+
+	t.Run("Expired entries are looked up again", func(t *testing.T) {
+		resolver := NewClusterResolver(identityServer, "/connection/stats", 0)
+		resolver.StatsUrl("gw-cluster", "test-key")
+		resolver.StatsUrl("gw-cluster", "test-key")
+		assert.Equal(t, 4, lookups)
+	})
+
+	t.Run("Gateway without address", func(t *testing.T) {
+		address = ""
+		resolver.Invalidate("gw-cluster")
+		_, err := resolver.StatsUrl("gw-cluster", "test-key")
+		assert.EqualError(t, err, "the gateway has no gateway server address")
+	})
+}
+
+func TestGatewayPoller_DetectCluster(t *testing.T) {
+	address, lookups := "127.0.0.1", 0
+	server := newMockIdentityServer(t, &address, &lookups)
+	identityServer, err := NewIdentityServer(server.URL)
+	assert.Nil(t, err)
+	resolver := NewClusterResolver(identityServer, "/connection/stats", time.Hour)
+
+	configured := "https://eu1.cloud.thethings.network/api/v3/gs/gateways/gw-cluster/connection/stats"
+	poller := NewGatewayPoller(GatewayConfig{ID: "gw-cluster"}, NewTTNApiService(configured, "test-key"), time.Hour)
+	poller.DetectCluster(resolver)
+	assert.Equal(t, "eu1", poller.labels["cluster"])
+
+	poller.detectCluster()
+	assert.Equal(t, server.URL+"/api/v3/gs/gateways/gw-cluster/connection/stats", poller.apiService.getUrl())
+	assert.Equal(t, "127.0.0.1", poller.labels["cluster"])
+	assert.Equal(t, configured, poller.url)
+}
+
+func TestGatewayServerHost(t *testing.T) {
+	tests := map[string]string{
+		"eu1.cloud.thethings.network":             "eu1.cloud.thethings.network",
+		"eu1.cloud.thethings.network:1700":        "eu1.cloud.thethings.network",
+		"wss://nam1.cloud.thethings.network:8887": "nam1.cloud.thethings.network",
+		"": "",
+	}
+	for address, want := range tests {
+		assert.Equal(t, want, gatewayServerHost(address), address)
+	}
+}
@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Metric groups that can be enabled per gateway
+const (
+	metricGroupMessages       = "messages"
+	metricGroupRoundTripTimes = "round_trip_times"
+	metricGroupMetadata       = "metadata"
+	metricGroupLocation       = "location"
+)
+
+var allMetricGroups = []string{metricGroupMessages, metricGroupRoundTripTimes, metricGroupMetadata, metricGroupLocation}
+
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Formats of gateway ids and EUIs in The Things Stack
+var (
+	gatewayIdPattern = regexp.MustCompile(`^[a-z0-9](?:-?[a-z0-9]){2,35}$`)
+	euiPattern       = regexp.MustCompile(`^[0-9A-F]{16}$`)
+)
+
+// Labels every gateway metric has, they can't be used as custom labels
+var reservedLabelNames = []string{"gateway_id", "gateway_eui", "cluster", "tenant"}
+
+// isReservedLabelName reports whether a label name is used by the exporter itself
+func isReservedLabelName(name string) bool {
+	return slices.Contains(reservedLabelNames, name) || slices.Contains(metadataLabelNames, name) || slices.Contains(seriesLabelNames, name)
+}
+
+// Config is the complete configuration of the exporter.
+// Values are taken from the environment first, then from the config file and then the defaults.
+type Config struct {
+	ApiKey                string              `yaml:"api_key"`
+	ApiKeyFile            string              `yaml:"api_key_file"`
+	BaseUrl               string              `yaml:"base_url"`
+	UrlStatsSuffix        string              `yaml:"url_stats_suffix"`
+	Transport             string              `yaml:"transport"`
+	GrpcAddress           string              `yaml:"grpc_address"`
+	GrpcInsecure          bool                `yaml:"grpc_insecure"`
+	ReadInterval          int                 `yaml:"read_interval"`
+	AutoDetectCluster     bool                `yaml:"auto_detect_cluster"`
+	IdentityServerUrl     string              `yaml:"identity_server_url"`
+	ClusterCacheTtl       int                 `yaml:"cluster_cache_ttl"`
+	MetadataTtl           int                 `yaml:"metadata_ttl"`
+	Labels                map[string]string   `yaml:"labels"`
+	AttributeLabels       map[string]string   `yaml:"attribute_labels"`
+	AuthInfoCheckInterval int                 `yaml:"auth_info_check_interval"`
+	Address               string              `yaml:"address"`
+	WebConfigFile         string              `yaml:"web_config_file"`
+	EnableRuntimeMetrics  bool                `yaml:"enable_runtime_metrics"`
+	EnableAppMetrics      bool                `yaml:"enable_app_metrics"`
+	LegacyMetricNames     bool                `yaml:"legacy_metric_names"`
+	StrictApiSchema       bool                `yaml:"strict_api_schema"`
+	StreamEvents          bool                `yaml:"stream_events"`
+	EnableWebhooks        bool                `yaml:"enable_webhooks"`
+	WebhookSecret         string              `yaml:"webhook_secret"`
+	WebhookSecretHeader   string              `yaml:"webhook_secret_header"`
+	EnableMqtt            bool                `yaml:"enable_mqtt"`
+	MqttUrl               string              `yaml:"mqtt_url"`
+	MqttApiKey            string              `yaml:"mqtt_api_key"`
+	MqttApplications      []MqttApplication   `yaml:"mqtt_applications"`
+	DeviceLocations       map[string]Location `yaml:"device_locations"`
+	DeviceReachWindow     int                 `yaml:"device_reach_window"`
+	EnableAdminApi        bool                `yaml:"enable_admin_api"`
+	AdminApiToken         string              `yaml:"admin_api_token"`
+	PersistGatewayChanges bool                `yaml:"persist_gateway_changes"`
+	EnableReloadApi       bool                `yaml:"enable_reload_api"`
+	LogLevel              string              `yaml:"log_level"`
+	LogFormat             string              `yaml:"log_format"`
+	Gateways              []GatewayConfig     `yaml:"gateways"`
+}
+
+// GatewayConfig describes a single monitored gateway. Empty values fall back to the global config.
+type GatewayConfig struct {
+	ID           string            `yaml:"id,omitempty" json:"gateway_id,omitempty"`
+	EUI          string            `yaml:"eui,omitempty" json:"gateway_eui,omitempty"`
+	Paused       bool              `yaml:"paused,omitempty" json:"paused"`
+	BaseUrl      string            `yaml:"base_url,omitempty" json:"base_url,omitempty"`
+	Cluster      string            `yaml:"cluster,omitempty" json:"cluster,omitempty"`
+	Tenant       string            `yaml:"tenant,omitempty" json:"tenant,omitempty"`
+	ApiKey       string            `yaml:"api_key,omitempty" json:"-"`
+	ApiKeyFile   string            `yaml:"api_key_file,omitempty" json:"api_key_file,omitempty"`
+	ReadInterval int               `yaml:"read_interval,omitempty" json:"read_interval,omitempty"`
+	Labels       map[string]string `yaml:"labels,omitempty" json:"labels,omitempty"`
+	Metrics      []string          `yaml:"metrics,omitempty" json:"metrics,omitempty"`
+}
+
+// name identifies the gateway in messages, by its id or by its EUI before the id is known
+func (gateway GatewayConfig) name() string {
+	if gateway.ID == "" {
+		return gateway.EUI
+	}
+	return gateway.ID
+}
+
+// normalizeEUI removes the separators of an EUI and uses upper case, e.g. 58-a0-cb-ff-fe-80-00-00 becomes 58A0CBFFFE800000
+func normalizeEUI(eui string) string {
+	eui = strings.NewReplacer(":", "", "-", "", " ", "").Replace(eui)
+	return strings.ToUpper(eui)
+}
+
+// MetricEnabled reports if a metric group is enabled for the gateway. No groups means all groups.
+func (gateway GatewayConfig) MetricEnabled(group string) bool {
+	return len(gateway.Metrics) == 0 || slices.Contains(gateway.Metrics, group)
+}
+
+// DefaultConfig returns the config used when nothing is configured
+func DefaultConfig() *Config {
+	return &Config{
+		BaseUrl:               "https://eu1.cloud.thethings.network/api/v3/gs/gateways/",
+		UrlStatsSuffix:        "/connection/stats",
+		Transport:             transportRest,
+		ReadInterval:          600,
+		ClusterCacheTtl:       3600,
+		MetadataTtl:           86400,
+		DeviceReachWindow:     86400,
+		AuthInfoCheckInterval: 3600,
+		Address:               ":9000",
+		EnableRuntimeMetrics:  true,
+		EnableAppMetrics:      true,
+		WebhookSecretHeader:   defaultWebhookSecretHeader,
+		LogLevel:              "info",
+		LogFormat:             "text",
+	}
+}
+
+// LoadConfig builds the config from the defaults, the optional config file and the environment
+func LoadConfig(path string) (*Config, error) {
+	config := DefaultConfig()
+	if path != "" {
+		if err := config.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := config.applyEnv(); err != nil {
+		return nil, err
+	}
+	if config.ReadInterval <= 0 {
+		return nil, errors.New("the read interval must be greater than 0")
+	}
+	if config.DeviceReachWindow <= 0 {
+		return nil, errors.New("the device reach window must be greater than 0")
+	}
+	if config.Transport != transportRest && config.Transport != transportGrpc {
+		return nil, fmt.Errorf("unknown transport %q, must be %s or %s", config.Transport, transportRest, transportGrpc)
+	}
+	if err := config.validateLabels(); err != nil {
+		return nil, err
+	}
+	if err := validateDeviceLocations(config.DeviceLocations); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// LoadConfigFile reads the config file on top of the defaults. A missing file results in the default config.
+func LoadConfigFile(path string) (*Config, error) {
+	config := DefaultConfig()
+	if err := config.loadFile(path); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func (config *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	if err := validateGateways(config.Gateways, gatewayNodes(&doc)); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (config *Config) applyEnv() error {
+	var err error
+	config.ApiKey = getEnvString("TTN_API_KEY", config.ApiKey)
+	config.ApiKeyFile = getEnvString("TTN_API_KEY_FILE", config.ApiKeyFile)
+	config.BaseUrl = getEnvString("TTN_BASE_URL", config.BaseUrl)
+	config.UrlStatsSuffix = getEnvString("TTN_URL_STATS_SUFFIX", config.UrlStatsSuffix)
+	config.Transport = getEnvString("TTN_TRANSPORT", config.Transport)
+	config.GrpcAddress = getEnvString("TTN_GRPC_ADDRESS", config.GrpcAddress)
+	config.IdentityServerUrl = getEnvString("TTN_IDENTITY_SERVER_URL", config.IdentityServerUrl)
+	config.Address = getEnvString("ADDRESS", config.Address)
+	config.WebConfigFile = getEnvString("WEB_CONFIG_FILE", config.WebConfigFile)
+	config.AdminApiToken = getEnvString("ADMIN_API_TOKEN", config.AdminApiToken)
+	config.WebhookSecret = getEnvString("WEBHOOK_SECRET", config.WebhookSecret)
+	config.WebhookSecretHeader = getEnvString("WEBHOOK_SECRET_HEADER", config.WebhookSecretHeader)
+	config.MqttUrl = getEnvString("MQTT_URL", config.MqttUrl)
+	config.MqttApiKey = getEnvString("MQTT_API_KEY", config.MqttApiKey)
+	config.LogLevel = getEnvString("LOG_LEVEL", config.LogLevel)
+	config.LogFormat = getEnvString("LOG_FORMAT", config.LogFormat)
+
+	if config.ReadInterval, err = getEnvInt("READ_INTERVAL", config.ReadInterval); err != nil {
+		return err
+	}
+	if config.AuthInfoCheckInterval, err = getEnvInt("AUTH_INFO_CHECK_INTERVAL", config.AuthInfoCheckInterval); err != nil {
+		return err
+	}
+	if config.ClusterCacheTtl, err = getEnvInt("CLUSTER_CACHE_TTL", config.ClusterCacheTtl); err != nil {
+		return err
+	}
+	if config.MetadataTtl, err = getEnvInt("METADATA_TTL", config.MetadataTtl); err != nil {
+		return err
+	}
+	if config.DeviceReachWindow, err = getEnvInt("DEVICE_REACH_WINDOW", config.DeviceReachWindow); err != nil {
+		return err
+	}
+	if config.Labels, err = getEnvMap("GATEWAY_LABELS", config.Labels); err != nil {
+		return err
+	}
+	if config.AttributeLabels, err = getEnvMap("GATEWAY_ATTRIBUTE_LABELS", config.AttributeLabels); err != nil {
+		return err
+	}
+	if config.GrpcInsecure, err = getEnvBool("TTN_GRPC_INSECURE", config.GrpcInsecure); err != nil {
+		return err
+	}
+	if config.AutoDetectCluster, err = getEnvBool("AUTO_DETECT_CLUSTER", config.AutoDetectCluster); err != nil {
+		return err
+	}
+	if config.EnableRuntimeMetrics, err = getEnvBool("ENABLE_RUNTIME_METRICS", config.EnableRuntimeMetrics); err != nil {
+		return err
+	}
+	if config.EnableAppMetrics, err = getEnvBool("ENABLE_APP_METRICS", config.EnableAppMetrics); err != nil {
+		return err
+	}
+	if config.LegacyMetricNames, err = getEnvBool("LEGACY_METRIC_NAMES", config.LegacyMetricNames); err != nil {
+		return err
+	}
+	if config.StrictApiSchema, err = getEnvBool("STRICT_API_SCHEMA", config.StrictApiSchema); err != nil {
+		return err
+	}
+	if config.StreamEvents, err = getEnvBool("STREAM_EVENTS", config.StreamEvents); err != nil {
+		return err
+	}
+	if config.EnableWebhooks, err = getEnvBool("ENABLE_WEBHOOKS", config.EnableWebhooks); err != nil {
+		return err
+	}
+	if config.EnableMqtt, err = getEnvBool("ENABLE_MQTT", config.EnableMqtt); err != nil {
+		return err
+	}
+	if config.EnableAdminApi, err = getEnvBool("ENABLE_ADMIN_API", config.EnableAdminApi); err != nil {
+		return err
+	}
+	if config.PersistGatewayChanges, err = getEnvBool("PERSIST_GATEWAY_CHANGES", config.PersistGatewayChanges); err != nil {
+		return err
+	}
+	if config.EnableReloadApi, err = getEnvBool("ENABLE_RELOAD_API", config.EnableReloadApi); err != nil {
+		return err
+	}
+
+	// Gateways from the env (comma separated) are added to the ones of the file
+	for _, gatewayId := range strings.Split(os.Getenv("TTN_GATEWAY_ID"), ",") {
+		gatewayId = strings.TrimSpace(gatewayId)
+		if gatewayId == "" || slices.ContainsFunc(config.Gateways, func(g GatewayConfig) bool { return g.ID == gatewayId }) {
+			continue
+		}
+		config.Gateways = append(config.Gateways, GatewayConfig{ID: gatewayId})
+	}
+	for _, eui := range strings.Split(os.Getenv("TTN_GATEWAY_EUI"), ",") {
+		eui = normalizeEUI(strings.TrimSpace(eui))
+		if eui == "" || slices.ContainsFunc(config.Gateways, func(g GatewayConfig) bool { return normalizeEUI(g.EUI) == eui }) {
+			continue
+		}
+		config.Gateways = append(config.Gateways, GatewayConfig{EUI: eui})
+	}
+	// Applications from the env (comma separated, with an optional @tenant) are added to the ones of the file
+	for _, application := range strings.Split(os.Getenv("MQTT_APPLICATIONS"), ",") {
+		app := parseMqttApplication(strings.TrimSpace(application))
+		if app.ID == "" || slices.ContainsFunc(config.MqttApplications, func(a MqttApplication) bool { return a.username() == app.username() }) {
+			continue
+		}
+		config.MqttApplications = append(config.MqttApplications, app)
+	}
+	return nil
+}
+
+// validateLabels checks the names of the labels of all gateways
+func (config *Config) validateLabels() error {
+	for name := range config.Labels {
+		if !validLabelName(name) {
+			return fmt.Errorf("labels: invalid label name %q", name)
+		}
+	}
+	for name, attribute := range config.AttributeLabels {
+		if !validLabelName(name) {
+			return fmt.Errorf("attribute_labels: invalid label name %q", name)
+		}
+		if attribute == "" {
+			return fmt.Errorf("attribute_labels: the attribute of label %q must not be empty", name)
+		}
+	}
+	return nil
+}
+
+// validLabelName reports whether name can be used as a custom label
+func validLabelName(name string) bool {
+	return labelNamePattern.MatchString(name) && !strings.HasPrefix(name, "__") && !isReservedLabelName(name)
+}
+
+// CustomLabelNames returns the sorted names of the labels of all gateways and of all labels configured on any gateway
+func (config *Config) CustomLabelNames() []string {
+	names := slices.Collect(maps.Keys(config.Labels))
+	for name := range config.AttributeLabels {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	for _, gateway := range config.Gateways {
+		for name := range gateway.Labels {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateGateways checks the gateways. If the yaml nodes are given, errors point at the line of the problem.
+func validateGateways(gateways []GatewayConfig, nodes []*yaml.Node) error {
+	seen := make(map[string]bool)
+	for i, gateway := range gateways {
+		var node *yaml.Node
+		if i < len(nodes) {
+			node = nodes[i]
+		}
+		if err := validateGateway(gateway, node); err != nil {
+			return err
+		}
+		if gateway.ID != "" && seen[gateway.ID] {
+			return configError(node, "id", "gateway %q is configured more than once", gateway.ID)
+		}
+		eui := normalizeEUI(gateway.EUI)
+		if eui != "" && seen[eui] {
+			return configError(node, "eui", "gateway eui %q is configured more than once", gateway.EUI)
+		}
+		seen[gateway.ID], seen[eui] = true, true
+	}
+	return nil
+}
+
+// validateGateway checks a single gateway, node is optional
+func validateGateway(gateway GatewayConfig, node *yaml.Node) error {
+	if gateway.ID == "" && gateway.EUI == "" {
+		return configError(node, "id", "gateway id or eui must not be empty")
+	}
+	if gateway.ID != "" && !gatewayIdPattern.MatchString(gateway.ID) {
+		return configError(node, "id", "invalid gateway id %q, it must have 3 to 36 lower case letters, digits and single dashes", gateway.ID)
+	}
+	if gateway.EUI != "" && !euiPattern.MatchString(normalizeEUI(gateway.EUI)) {
+		return configError(node, "eui", "invalid gateway eui %q, it must have 16 hex digits", gateway.EUI)
+	}
+	if gateway.ReadInterval < 0 {
+		return configError(node, "read_interval", "gateway %q: read_interval must not be negative", gateway.name())
+	}
+	if gateway.ApiKey != "" && gateway.ApiKeyFile != "" {
+		return configError(node, "api_key_file", "gateway %q: only one of api_key and api_key_file can be set", gateway.name())
+	}
+	for name := range gateway.Labels {
+		if !validLabelName(name) {
+			return configError(node, "labels", "gateway %q: invalid label name %q", gateway.name(), name)
+		}
+	}
+	for _, group := range gateway.Metrics {
+		if !slices.Contains(allMetricGroups, group) {
+			return configError(node, "metrics", "gateway %q: unknown metric group %q, must be one of %s", gateway.name(), group, strings.Join(allMetricGroups, ", "))
+		}
+	}
+	return nil
+}
+
+// configError creates an error that points at the line of the field in the gateway node
+func configError(node *yaml.Node, field string, format string, args ...any) error {
+	err := fmt.Errorf(format, args...)
+	if node == nil {
+		return err
+	}
+	line := node.Line
+	if value := mappingValue(node, field); value != nil {
+		line = value.Line
+	}
+	return fmt.Errorf("line %d: %w", line, err)
+}
+
+// gatewayNodes returns the yaml nodes of the gateways list
+func gatewayNodes(doc *yaml.Node) []*yaml.Node {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil
+	}
+	gateways := mappingValue(doc.Content[0], "gateways")
+	if gateways == nil || gateways.Kind != yaml.SequenceNode {
+		return nil
+	}
+	return gateways.Content
+}
+
+// mappingValue returns the value node of a key in a mapping node
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// SaveGatewaysToConfigFile replaces the gateways list in the config file.
+// All other content of the file, including comments, is kept.
+func SaveGatewaysToConfigFile(path string, gateways []GatewayConfig) error {
+	var doc yaml.Node
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("config file %s: top level must be a mapping", path)
+	}
+
+	var gatewaysNode yaml.Node
+	if err := gatewaysNode.Encode(gateways); err != nil {
+		return fmt.Errorf("encoding gateways: %w", err)
+	}
+
+	replaced := false
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "gateways" {
+			root.Content[i+1] = &gatewaysNode
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "gateways"}, &gatewaysNode)
+	}
+
+	out, err := yaml.Marshal(&doc)
+	if err != nil {
+		return fmt.Errorf("encoding config file: %w", err)
+	}
+
+	// The file may contain API keys, so keep its permissions
+	mode := os.FileMode(0o600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated config behind
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, out, mode); err != nil {
+		return fmt.Errorf("writing config file: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("writing config file: %w", err)
+	}
+	return nil
+}
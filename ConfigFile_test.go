@@ -0,0 +1,263 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("Defaults without file", func(t *testing.T) {
+		config, err := LoadConfig("")
+		assert.Nil(t, err)
+		assert.Equal(t, DefaultConfig(), config)
+	})
+
+	t.Run("Missing file", func(t *testing.T) {
+		config, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+		assert.Nil(t, err)
+		assert.Equal(t, DefaultConfig(), config)
+	})
+
+	t.Run("Full file", func(t *testing.T) {
+		path := writeConfigFile(t, `
+api_key: global-key
+read_interval: 300
+enable_runtime_metrics: false
+gateways:
+  - id: gw-1
+    labels:
+      site: berlin
+    metrics: [messages]
+  - id: gw-2
+    base_url: https://nam1.cloud.thethings.network/api/v3/gs/gateways/
+    api_key: gw-2-key
+    read_interval: 60
+    labels:
+      rack: r1
+`)
+		config, err := LoadConfig(path)
+		assert.Nil(t, err)
+		assert.Equal(t, "global-key", config.ApiKey)
+		assert.Equal(t, 300, config.ReadInterval)
+		assert.False(t, config.EnableRuntimeMetrics)
+		assert.True(t, config.EnableAppMetrics)
+		assert.Equal(t, []GatewayConfig{
+			{ID: "gw-1", Labels: map[string]string{"site": "berlin"}, Metrics: []string{"messages"}},
+			{
+				ID:           "gw-2",
+				BaseUrl:      "https://nam1.cloud.thethings.network/api/v3/gs/gateways/",
+				ApiKey:       "gw-2-key",
+				ReadInterval: 60,
+				Labels:       map[string]string{"rack": "r1"},
+			},
+		}, config.Gateways)
+		assert.Equal(t, []string{"rack", "site"}, config.CustomLabelNames())
+	})
+
+	t.Run("Env overrides file", func(t *testing.T) {
+		path := writeConfigFile(t, "read_interval: 300\naddress: \":9100\"\ngateways:\n  - id: gw-1\n")
+		t.Setenv("READ_INTERVAL", "30")
+		t.Setenv("TTN_GATEWAY_ID", "gw-1, gw-env")
+
+		config, err := LoadConfig(path)
+		assert.Nil(t, err)
+		assert.Equal(t, 30, config.ReadInterval)
+		assert.Equal(t, ":9100", config.Address)
+		assert.Equal(t, []GatewayConfig{{ID: "gw-1"}, {ID: "gw-env"}}, config.Gateways)
+	})
+
+	t.Run("Gateway EUIs from env", func(t *testing.T) {
+		path := writeConfigFile(t, "gateways:\n  - eui: 58a0cbfffe800001\n")
+		t.Setenv("TTN_GATEWAY_EUI", "58-A0-CB-FF-FE-80-00-01, 58:a0:cb:ff:fe:80:00:02")
+
+		config, err := LoadConfig(path)
+		assert.Nil(t, err)
+		assert.Equal(t, []GatewayConfig{{EUI: "58a0cbfffe800001"}, {EUI: "58A0CBFFFE800002"}}, config.Gateways)
+	})
+
+	t.Run("Labels of all gateways", func(t *testing.T) {
+		path := writeConfigFile(t, "labels:\n  customer: acme\nattribute_labels:\n  region: region\ngateways:\n  - id: gw-1\n    labels:\n      site: berlin\n")
+		t.Setenv("GATEWAY_LABELS", "customer=other")
+
+		config, err := LoadConfig(path)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]string{"customer": "other"}, config.Labels)
+		assert.Equal(t, map[string]string{"region": "region"}, config.AttributeLabels)
+		assert.Equal(t, []string{"customer", "region", "site"}, config.CustomLabelNames())
+	})
+
+	t.Run("Invalid label of all gateways", func(t *testing.T) {
+		t.Setenv("GATEWAY_ATTRIBUTE_LABELS", "cluster=region")
+
+		_, err := LoadConfig("")
+		assert.EqualError(t, err, "attribute_labels: invalid label name \"cluster\"")
+	})
+
+	t.Run("Invalid env value", func(t *testing.T) {
+		t.Setenv("ENABLE_APP_METRICS", "maybe")
+
+		_, err := LoadConfig("")
+		assert.EqualError(t, err, "invalid ENABLE_APP_METRICS: strconv.ParseBool: parsing \"maybe\": invalid syntax")
+	})
+
+	t.Run("gRPC transport", func(t *testing.T) {
+		path := writeConfigFile(t, "transport: grpc\ngrpc_address: localhost:1884\n")
+		t.Setenv("TTN_GRPC_INSECURE", "true")
+
+		config, err := LoadConfig(path)
+		assert.Nil(t, err)
+		assert.Equal(t, transportGrpc, config.Transport)
+		assert.Equal(t, "localhost:1884", config.GrpcAddress)
+		assert.True(t, config.GrpcInsecure)
+	})
+
+	t.Run("Invalid transport", func(t *testing.T) {
+		t.Setenv("TTN_TRANSPORT", "mqtt")
+
+		_, err := LoadConfig("")
+		assert.EqualError(t, err, "unknown transport \"mqtt\", must be rest or grpc")
+	})
+
+	t.Run("Device locations", func(t *testing.T) {
+		path := writeConfigFile(t, `
+device_locations:
+  app-1/dev-1:
+    latitude: 52.52
+    longitude: 13.405
+  app-1/dev-2: {latitude: 48.137, longitude: 11.575, altitude: 520}
+`)
+		config, err := LoadConfig(path)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]Location{
+			"app-1/dev-1": {Latitude: 52.52, Longitude: 13.405},
+			"app-1/dev-2": {Latitude: 48.137, Longitude: 11.575, Altitude: 520},
+		}, config.DeviceLocations)
+	})
+
+	t.Run("Invalid device locations", func(t *testing.T) {
+		_, err := LoadConfig(writeConfigFile(t, "device_locations:\n  dev-1: {latitude: 52.52, longitude: 13.405}\n"))
+		assert.EqualError(t, err, "device_locations: invalid device \"dev-1\", it must be application-id/device-id")
+
+		_, err = LoadConfig(writeConfigFile(t, "device_locations:\n  app-1/dev-1: {latitude: 13.405, longitude: 252.52}\n"))
+		assert.EqualError(t, err, "device_locations: device \"app-1/dev-1\" has an invalid location")
+	})
+
+	t.Run("Invalid read interval", func(t *testing.T) {
+		path := writeConfigFile(t, "read_interval: 0\n")
+
+		_, err := LoadConfig(path)
+		assert.EqualError(t, err, "the read interval must be greater than 0")
+	})
+
+	t.Run("Invalid device reach window", func(t *testing.T) {
+		t.Setenv("DEVICE_REACH_WINDOW", "0")
+
+		_, err := LoadConfig("")
+		assert.EqualError(t, err, "the device reach window must be greater than 0")
+	})
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{
+			name:     "Unknown field",
+			content:  "read_interval: 300\nunknown: true\n",
+			expected: "line 2: field unknown not found in type main.Config",
+		},
+		{
+			name:     "Wrong type",
+			content:  "gateways:\n  - id: gw-1\n    read_interval: often\n",
+			expected: "line 3: cannot unmarshal !!str `often` into int",
+		},
+		{
+			name:     "Missing id",
+			content:  "gateways:\n  - id: gw-1\n  - labels:\n      site: berlin\n",
+			expected: "line 3: gateway id or eui must not be empty",
+		},
+		{
+			name:     "Duplicate id",
+			content:  "gateways:\n  - id: gw-1\n  - id: gw-1\n",
+			expected: "line 3: gateway \"gw-1\" is configured more than once",
+		},
+		{
+			name:     "Invalid gateway id",
+			content:  "gateways:\n  - id: My_Gateway\n",
+			expected: "line 2: invalid gateway id \"My_Gateway\", it must have 3 to 36 lower case letters, digits and single dashes",
+		},
+		{
+			name:     "Invalid gateway eui",
+			content:  "gateways:\n  - eui: 58A0CBFFFE80\n",
+			expected: "line 2: invalid gateway eui \"58A0CBFFFE80\", it must have 16 hex digits",
+		},
+		{
+			name:     "Duplicate gateway eui",
+			content:  "gateways:\n  - eui: 58A0CBFFFE800001\n  - id: gw-1\n    eui: 58-a0-cb-ff-fe-80-00-01\n",
+			expected: "line 4: gateway eui \"58-a0-cb-ff-fe-80-00-01\" is configured more than once",
+		},
+		{
+			name:     "Invalid label name",
+			content:  "gateways:\n  - id: gw-1\n    labels:\n      my-site: berlin\n",
+			expected: "line 4: gateway \"gw-1\": invalid label name \"my-site\"",
+		},
+		{
+			name:     "Reserved label name",
+			content:  "gateways:\n  - id: gw-1\n    labels:\n      gateway_id: other\n",
+			expected: "line 4: gateway \"gw-1\": invalid label name \"gateway_id\"",
+		},
+		{
+			name:     "Cluster label name",
+			content:  "gateways:\n  - id: gw-1\n    labels:\n      cluster: eu1\n",
+			expected: "line 4: gateway \"gw-1\": invalid label name \"cluster\"",
+		},
+		{
+			name:     "Metadata label name",
+			content:  "gateways:\n  - id: gw-1\n    labels:\n      model_id: other\n",
+			expected: "line 4: gateway \"gw-1\": invalid label name \"model_id\"",
+		},
+		{
+			name:     "Unknown metric group",
+			content:  "gateways:\n  - id: gw-1\n    metrics: [messages, rssi]\n",
+			expected: "line 3: gateway \"gw-1\": unknown metric group \"rssi\", must be one of messages, round_trip_times, metadata, location",
+		},
+		{
+			name:     "Key and key file",
+			content:  "gateways:\n  - id: gw-1\n    api_key: key\n    api_key_file: /key\n",
+			expected: "line 4: gateway \"gw-1\": only one of api_key and api_key_file can be set",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfigFile(writeConfigFile(t, tt.content))
+			assert.ErrorContains(t, err, tt.expected)
+		})
+	}
+}
+
+func TestGatewayConfig_MetricEnabled(t *testing.T) {
+	assert.True(t, GatewayConfig{}.MetricEnabled(metricGroupMessages))
+	assert.True(t, GatewayConfig{Metrics: []string{metricGroupMessages}}.MetricEnabled(metricGroupMessages))
+	assert.False(t, GatewayConfig{Metrics: []string{metricGroupMessages}}.MetricEnabled(metricGroupRoundTripTimes))
+}
+
+func TestNormalizeEUI(t *testing.T) {
+	assert.Equal(t, "58A0CBFFFE800001", normalizeEUI("58a0cbfffe800001"))
+	assert.Equal(t, "58A0CBFFFE800001", normalizeEUI("58-a0-cb-ff-fe-80-00-01"))
+	assert.Equal(t, "58A0CBFFFE800001", normalizeEUI("58:A0:CB:FF:FE:80:00:01"))
+	assert.Equal(t, "", normalizeEUI(""))
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Gateway id of the uplinks forwarded by Packet Broker from the gateways of other networks
+const packetBrokerGatewayId = "packetbroker"
+
+// Keys of the locations of an uplink in order of preference, the location decoded from the
+// payload is more recent than the one set in the end device registry
+var uplinkLocationKeys = []string{"frm-payload", "user"}
+
+// antennaRegistry keeps the configured antennas of the polled gateways
+type antennaRegistry struct {
+	mu        sync.RWMutex
+	byGateway map[string][]GatewayAntenna
+}
+
+// set replaces the antennas of a gateway
+func (r *antennaRegistry) set(gatewayId string, antennas []GatewayAntenna) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.byGateway[gatewayId] = antennas
+}
+
+// delete forgets the antennas of a gateway
+func (r *antennaRegistry) delete(gatewayId string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.byGateway, gatewayId)
+}
+
+// location returns the configured location of an antenna of the gateway
+func (r *antennaRegistry) location(gatewayId string, antenna int) (Location, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	antennas := r.byGateway[gatewayId]
+	if antenna < 0 || antenna >= len(antennas) || antennas[antenna].Location == nil {
+		return Location{}, false
+	}
+	return *antennas[antenna].Location, true
+}
+
+// antennaLocations are the antennas from the metadata of the polled gateways
+var antennaLocations = &antennaRegistry{byGateway: make(map[string][]GatewayAntenna)}
+
+// locationTable is the static location of devices that don't send one with their uplinks
+type locationTable struct {
+	mu      sync.RWMutex
+	devices map[string]Location
+}
+
+// set replaces the locations, the devices are identified by application-id/device-id
+func (t *locationTable) set(devices map[string]Location) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.devices = maps.Clone(devices)
+}
+
+func (t *locationTable) get(device string) (Location, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	location, ok := t.devices[device]
+	return location, ok
+}
+
+// deviceLocations are the device_locations of the config file
+var deviceLocations = &locationTable{}
+
+// rangeSet keeps the largest reception distance of every gateway
+type rangeSet struct {
+	mu        sync.Mutex
+	byGateway map[string]float64
+}
+
+// add records a reception distance and returns the largest distance of the gateway
+func (s *rangeSet) add(gatewayId string, distance float64) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	maxDistance := max(s.byGateway[gatewayId], distance)
+	s.byGateway[gatewayId] = maxDistance
+	return maxDistance
+}
+
+// receptionRanges are the largest distances since the start of the exporter
+var receptionRanges = &rangeSet{byGateway: make(map[string]float64)}
+
+// deviceLocation returns the location of the device that sent the uplink. Locations sent with
+// the uplink take precedence over the device_locations of the config file.
+func (uplink ApplicationUplink) deviceLocation() (Location, bool) {
+	locations := uplink.UplinkMessage.Locations
+	for _, key := range uplinkLocationKeys {
+		if location, ok := locations[key]; ok {
+			return location, true
+		}
+	}
+	// Other services that set a location, in a stable order
+	if len(locations) > 0 {
+		return locations[slices.Sorted(maps.Keys(locations))[0]], true
+	}
+	return deviceLocations.get(uplink.device())
+}
+
+// antennaLocation returns the location of the antenna that received the uplink. The location
+// sent with the uplink is only there for gateways with a public location, the others are
+// taken from the metadata of the polled gateways.
+func (rx RxMetadata) antennaLocation() (Location, bool) {
+	if rx.Location != nil {
+		return *rx.Location, true
+	}
+	return antennaLocations.location(rx.GatewayIds.GatewayId, rx.AntennaIndex)
+}
+
+// observeDistance adds the distance between the device and the antenna that received the uplink
+// to the coverage metrics with the labels of the gateway. Gateways of other networks all have
+// the same id and are left out.
+func observeDistance(rx RxMetadata, device Location, labels prometheus.Labels) {
+	gatewayId := rx.GatewayIds.GatewayId
+	if gatewayId == packetBrokerGatewayId {
+		return
+	}
+	antenna, ok := rx.antennaLocation()
+	if !ok {
+		return
+	}
+	distance := device.DistanceTo(antenna)
+	receptionDistance.With(labels).Observe(distance)
+	receptionMaxDistance.With(labels).Set(receptionRanges.add(gatewayId, distance))
+}
+
+// validateDeviceLocations checks the keys and coordinates of the device_locations
+func validateDeviceLocations(devices map[string]Location) error {
+	for device, location := range devices {
+		applicationId, deviceId, ok := strings.Cut(device, "/")
+		if !ok || applicationId == "" || deviceId == "" || strings.Contains(deviceId, "/") {
+			return fmt.Errorf("device_locations: invalid device %q, it must be application-id/device-id", device)
+		}
+		if location.Latitude < -90 || location.Latitude > 90 || location.Longitude < -180 || location.Longitude > 180 {
+			return fmt.Errorf("device_locations: device %q has an invalid location", device)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// 0.01 degrees of latitude in meters
+const centiDegree = 1111.95
+
+func parseUplink(t *testing.T, body string) ApplicationUplink {
+	var uplink ApplicationUplink
+	assert.Nil(t, json.Unmarshal([]byte(body), &uplink))
+	return uplink
+}
+
+func TestObserveDistance(t *testing.T) {
+	t.Cleanup(resetUplinkMetrics)
+	t.Cleanup(func() { deviceLocations.set(nil) })
+
+	antennaLocations.set("gw-coverage-2", []GatewayAntenna{{Gain: 3}, {Location: &Location{Latitude: 52.50, Longitude: 13.405}}})
+	defer antennaLocations.delete("gw-coverage-2")
+	deviceLocations.set(map[string]Location{"app-1/dev-2": {Latitude: 52.54, Longitude: 13.405}})
+
+	// The public location of gw-coverage-1 is sent with the uplink, the one of gw-coverage-2 comes from its metadata
+	rxMetadata := `[
+		{"gateway_ids": {"gateway_id": "gw-coverage-1"}, "location": {"latitude": 52.52, "longitude": 13.405, "source": "SOURCE_REGISTRY"}},
+		{"gateway_ids": {"gateway_id": "gw-coverage-2"}, "antenna_index": 1},
+		{"gateway_ids": {"gateway_id": "gw-coverage-3"}},
+		{"gateway_ids": {"gateway_id": "packetbroker"}, "location": {"latitude": 48.137, "longitude": 11.575}}
+	]`
+	observeUplink(parseUplink(t, `{
+		"end_device_ids": {"device_id": "dev-1", "application_ids": {"application_id": "app-1"}},
+		"uplink_message": {
+			"rx_metadata": `+rxMetadata+`,
+			"locations": {
+				"user": {"latitude": 52.52, "longitude": 13.405, "source": "SOURCE_REGISTRY"},
+				"frm-payload": {"latitude": 52.53, "longitude": 13.405, "source": "SOURCE_GPS"}
+			}
+		}
+	}`))
+	assert.InDelta(t, centiDegree, testutil.ToFloat64(receptionMaxDistance.WithLabelValues("gw-coverage-1", "", "", "")), 1)
+	assert.InDelta(t, 3*centiDegree, testutil.ToFloat64(receptionMaxDistance.WithLabelValues("gw-coverage-2", "", "", "")), 1)
+
+	// The location of dev-2 is configured, dev-3 has none
+	for _, device := range []string{"dev-2", "dev-3"} {
+		observeUplink(parseUplink(t, `{
+			"end_device_ids": {"device_id": "`+device+`", "application_ids": {"application_id": "app-1"}},
+			"uplink_message": {"rx_metadata": `+rxMetadata+`}
+		}`))
+	}
+	assert.InDelta(t, 2*centiDegree, testutil.ToFloat64(receptionMaxDistance.WithLabelValues("gw-coverage-1", "", "", "")), 1)
+	assert.InDelta(t, 4*centiDegree, testutil.ToFloat64(receptionMaxDistance.WithLabelValues("gw-coverage-2", "", "", "")), 1)
+
+	// Gateways without a known location and Packet Broker have no coverage series
+	assert.Equal(t, 2, testutil.CollectAndCount(receptionDistance))
+	assert.Equal(t, 2, testutil.CollectAndCount(receptionMaxDistance))
+	assert.Equal(t, 4, testutil.CollectAndCount(uplinksReceived))
+}
+
+func TestDeviceLocation(t *testing.T) {
+	t.Run("Other services", func(t *testing.T) {
+		uplink := parseUplink(t, `{"uplink_message": {"locations": {
+			"frm-payload-wifi": {"latitude": 1, "longitude": 2},
+			"frm-payload-gnss": {"latitude": 3, "longitude": 4}
+		}}}`)
+		location, ok := uplink.deviceLocation()
+		assert.True(t, ok)
+		assert.Equal(t, Location{Latitude: 3, Longitude: 4}, location)
+	})
+
+	t.Run("No location", func(t *testing.T) {
+		_, ok := parseUplink(t, `{"uplink_message": {}}`).deviceLocation()
+		assert.False(t, ok)
+	})
+}
+
+func TestAntennaRegistry(t *testing.T) {
+	registry := &antennaRegistry{byGateway: make(map[string][]GatewayAntenna)}
+	registry.set("gw-1", []GatewayAntenna{{Location: &Location{Latitude: 52.52}}, {Gain: 3}})
+
+	location, ok := registry.location("gw-1", 0)
+	assert.True(t, ok)
+	assert.Equal(t, Location{Latitude: 52.52}, location)
+	for _, antenna := range []int{-1, 1, 2} {
+		_, ok = registry.location("gw-1", antenna)
+		assert.False(t, ok)
+	}
+
+	registry.delete("gw-1")
+	_, ok = registry.location("gw-1", 0)
+	assert.False(t, ok)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Interval of the updates of the device reach metrics
+const deviceReachUpdateInterval = time.Minute
+
+// deviceReach keeps the gateways that received uplinks of every device within the window.
+// The devices are only kept in memory, they are never exported as labels.
+type deviceReach struct {
+	mu     sync.Mutex
+	window time.Duration
+	// The time of the last uplink of every device by gateway
+	devices map[string]map[string]time.Time
+	// Gateways with reach series, they are set to 0 once their devices left the window
+	gateways map[string]struct{}
+}
+
+func newDeviceReach(window time.Duration) *deviceReach {
+	return &deviceReach{
+		window:   window,
+		devices:  make(map[string]map[string]time.Time),
+		gateways: make(map[string]struct{}),
+	}
+}
+
+// heardDevices are the devices the gateways received uplinks of within the device reach window
+var heardDevices = newDeviceReach(24 * time.Hour)
+
+// add records that the gateway received an uplink of the device at the given time. Gateways of
+// other networks all have the same id and are left out.
+func (r *deviceReach) add(device string, gatewayId string, at time.Time) {
+	if gatewayId == packetBrokerGatewayId {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	gateways, ok := r.devices[device]
+	if !ok {
+		gateways = make(map[string]time.Time)
+		r.devices[device] = gateways
+	}
+	gateways[gatewayId] = at
+}
+
+// update forgets the uplinks older than the window and sets the number of devices every gateway
+// received and the number of devices no other gateway received
+func (r *deviceReach) update(now time.Time) {
+	gatewayMetricsMu.RLock()
+	defer gatewayMetricsMu.RUnlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	devices := make(map[string]int)
+	exclusive := make(map[string]int)
+	for device, gateways := range r.devices {
+		for gatewayId, last := range gateways {
+			if now.Sub(last) > r.window {
+				delete(gateways, gatewayId)
+			}
+		}
+		if len(gateways) == 0 {
+			delete(r.devices, device)
+			continue
+		}
+		for gatewayId := range gateways {
+			devices[gatewayId]++
+			r.gateways[gatewayId] = struct{}{}
+			if len(gateways) == 1 {
+				exclusive[gatewayId]++
+			}
+		}
+	}
+
+	for gatewayId := range r.gateways {
+		labels := monitoredGatewayLabels.labels(gatewayId)
+		uplinkDevices.With(labels).Set(float64(devices[gatewayId]))
+		uplinkExclusiveDevices.With(labels).Set(float64(exclusive[gatewayId]))
+	}
+}
+
+// Run updates the metrics every interval until the context is done
+func (r *deviceReach) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r.update(now)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeviceReach(t *testing.T) {
+	t.Cleanup(resetUplinkMetrics)
+	reach := newDeviceReach(time.Hour)
+	start := time.Date(2025, 1, 2, 3, 0, 0, 0, time.UTC)
+
+	// dev-1 is heard by both gateways, dev-2 and dev-3 only by gw-reach-1
+	reach.add("app-1/dev-1", "gw-reach-1", start)
+	reach.add("app-1/dev-1", "gw-reach-2", start.Add(30*time.Minute))
+	reach.add("app-1/dev-2", "gw-reach-1", start.Add(10*time.Minute))
+	reach.add("app-2/dev-2", "gw-reach-1", start.Add(20*time.Minute))
+	reach.add("app-2/dev-2", "gw-reach-1", start.Add(25*time.Minute))
+	// Uplinks of Packet Broker neither count as a gateway nor make the others less exclusive
+	reach.add("app-1/dev-2", packetBrokerGatewayId, start.Add(15*time.Minute))
+
+	reach.update(start.Add(40 * time.Minute))
+	assert.Equal(t, 3.0, testutil.ToFloat64(uplinkDevices.WithLabelValues("gw-reach-1", "", "", "")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(uplinkExclusiveDevices.WithLabelValues("gw-reach-1", "", "", "")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(uplinkDevices.WithLabelValues("gw-reach-2", "", "", "")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(uplinkExclusiveDevices.WithLabelValues("gw-reach-2", "", "", "")))
+	assert.Equal(t, 2, testutil.CollectAndCount(uplinkDevices))
+
+	// The uplink of dev-1 at gw-reach-1 left the window, so only gw-reach-2 hears it
+	reach.update(start.Add(70 * time.Minute))
+	assert.Equal(t, 2.0, testutil.ToFloat64(uplinkDevices.WithLabelValues("gw-reach-1", "", "", "")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(uplinkExclusiveDevices.WithLabelValues("gw-reach-1", "", "", "")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(uplinkExclusiveDevices.WithLabelValues("gw-reach-2", "", "", "")))
+
+	// Gateways keep their series once all devices left the window
+	reach.update(start.Add(3 * time.Hour))
+	assert.Equal(t, 0.0, testutil.ToFloat64(uplinkDevices.WithLabelValues("gw-reach-1", "", "", "")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(uplinkDevices.WithLabelValues("gw-reach-2", "", "", "")))
+	assert.Equal(t, 2, testutil.CollectAndCount(uplinkExclusiveDevices))
+	assert.Empty(t, reach.devices)
+}
+
+func TestDeviceReach_Run(t *testing.T) {
+	t.Cleanup(resetUplinkMetrics)
+	reach := newDeviceReach(time.Hour)
+	reach.add("app-1/dev-1", "gw-reach-run", time.Now())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reach.Run(ctx, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(uplinkExclusiveDevices.WithLabelValues("gw-reach-run", "", "", "")) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
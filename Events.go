@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Events of the Gateway Server the pollers subscribe to
+var gatewayEventNames = []string{
+	"gs.gateway.connect",
+	"gs.gateway.disconnect",
+	"gs.up.receive",
+	"gs.down.send",
+	"gs.down.tx.success",
+	"gs.down.tx.fail",
+	"gs.status.receive",
+}
+
+// Backoff between the reconnects of an event stream
+const (
+	eventStreamMinBackoff = time.Second
+	eventStreamMaxBackoff = 5 * time.Minute
+)
+
+// Event is an event of the Events API of The Things Stack
+type Event struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+}
+
+// eventStreamClient has no timeout, a stream stays open until it is cancelled or the server closes it
+var eventStreamClient = &http.Client{}
+
+// streamEvents subscribes to the events of a gateway at the Events API of the server of
+// apiUrl and calls handle for every event until the stream ends or ctx is done.
+// connected is called once the server accepted the subscription.
+func streamEvents(ctx context.Context, apiUrl string, gatewayId string, apiKey string, connected func(), handle func(Event)) error {
+	body, err := json.Marshal(map[string]any{
+		"identifiers": []map[string]any{{"gateway_ids": map[string]string{"gateway_id": gatewayId}}},
+		"names":       gatewayEventNames,
+	})
+	if err != nil {
+		return fmt.Errorf("marshalling request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiRootUrl(apiUrl)+"/api/v3/events", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := eventStreamClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("making HTTP request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{StatusCode: resp.StatusCode}
+	}
+	connected()
+
+	// Every line is a message of the stream, either an event or an error
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		var message struct {
+			Result *Event `json:"result"`
+			Error  *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			return fmt.Errorf("unmarshalling event: %w", err)
+		}
+		if message.Error != nil {
+			return fmt.Errorf("event stream failed: %s", message.Error.Message)
+		}
+		// The server confirms the subscription with a stream start event
+		if message.Result != nil && message.Result.Name != "events.stream.start" {
+			handle(*message.Result)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading event stream: %w", err)
+	}
+	return nil
+}
+
+// nextBackoff doubles the wait before the next reconnect up to the maximum
+func nextBackoff(backoff time.Duration) time.Duration {
+	return min(max(2*backoff, eventStreamMinBackoff), eventStreamMaxBackoff)
+}
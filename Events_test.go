@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// eventServer streams the given lines to every subscription
+func eventServer(t *testing.T, lines ...string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/api/v3/events", r.URL.Path)
+		if r.Header.Get("Authorization") != "Bearer test-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var request struct {
+			Identifiers []struct {
+				GatewayIds struct {
+					GatewayId string `json:"gateway_id"`
+				} `json:"gateway_ids"`
+			} `json:"identifiers"`
+			Names []string `json:"names"`
+		}
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&request))
+		assert.Len(t, request.Identifiers, 1)
+		assert.Equal(t, gatewayEventNames, request.Names)
+
+		for _, line := range lines {
+			fmt.Fprintln(w, line)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestStreamEvents(t *testing.T) {
+	t.Run("Events", func(t *testing.T) {
+		server := eventServer(t,
+			`{"result": {"name": "events.stream.start"}}`,
+			`{"result": {"name": "gs.gateway.connect", "time": "2025-01-02T03:04:05Z", "identifiers": [{"gateway_ids": {"gateway_id": "gw-events"}}]}}`,
+			`{"result": {"name": "gs.up.receive", "time": "2025-01-02T03:04:06Z"}}`,
+		)
+
+		connected := false
+		var events []Event
+		err := streamEvents(context.Background(), server.URL+"/api/v3/gs/gateways/gw-events/connection/stats", "gw-events", "test-key", func() {
+			connected = true
+		}, func(event Event) {
+			events = append(events, event)
+		})
+		assert.Nil(t, err)
+		assert.True(t, connected)
+		assert.Equal(t, []Event{
+			{Name: "gs.gateway.connect", Time: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)},
+			{Name: "gs.up.receive", Time: time.Date(2025, 1, 2, 3, 4, 6, 0, time.UTC)},
+		}, events)
+	})
+
+	t.Run("Stream error", func(t *testing.T) {
+		server := eventServer(t, `{"error": {"code": 7, "message": "no gateway rights"}}`)
+
+		err := streamEvents(context.Background(), server.URL, "gw-events", "test-key", func() {}, func(Event) {})
+		assert.EqualError(t, err, "event stream failed: no gateway rights")
+	})
+
+	t.Run("Rejected API key", func(t *testing.T) {
+		server := eventServer(t)
+
+		err := streamEvents(context.Background(), server.URL, "gw-events", "wrong-key", func() {
+			t.Error("the stream must not be connected")
+		}, func(Event) {})
+		assert.Equal(t, &StatusError{StatusCode: http.StatusUnauthorized}, err)
+	})
+}
+
+func TestNextBackoff(t *testing.T) {
+	var backoff time.Duration
+	var backoffs []time.Duration
+	for range 11 {
+		backoff = nextBackoff(backoff)
+		backoffs = append(backoffs, backoff)
+	}
+	assert.Equal(t, []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 32 * time.Second,
+		64 * time.Second, 128 * time.Second, 256 * time.Second, 5 * time.Minute, 5 * time.Minute,
+	}, backoffs)
+}
+
+// eventLabels returns the labels of the event counter of the poller
+func eventLabels(poller *GatewayPoller, name string) prometheus.Labels {
+	labels := maps.Clone(poller.labels)
+	labels["name"] = name
+	return labels
+}
+
+func TestGatewayPoller_Events(t *testing.T) {
+	server := eventServer(t,
+		`{"result": {"name": "gs.gateway.connect"}}`,
+		`{"result": {"name": "gs.up.receive"}}`,
+		`{"result": {"name": "gs.up.receive"}}`,
+		`{"result": {"name": "gs.gateway.disconnect"}}`,
+	)
+	poller := NewGatewayPoller(GatewayConfig{ID: "gw-events"}, NewTTNApiService(server.URL+"/api/v3/gs/gateways/gw-events/connection/stats", "test-key"), time.Hour)
+	poller.StreamEvents()
+	defer deleteGatewayMetrics("gw-events")
+
+	// Only the event stream runs, the first poll is an hour away
+	go poller.run(false, time.Hour)
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(gatewayEvents.With(eventLabels(poller, "gs.gateway.disconnect"))) >= 1
+	}, 5*time.Second, 10*time.Millisecond)
+	poller.Stop()
+
+	// The server closes the stream after the last event, it may have been opened again since
+	assert.GreaterOrEqual(t, testutil.ToFloat64(gatewayEvents.With(eventLabels(poller, "gs.up.receive"))), 2.0)
+	assert.Equal(t, 0.0, testutil.ToFloat64(gatewayConnected.With(poller.labels)))
+}
+
+func TestGatewayPoller_HandleEvent(t *testing.T) {
+	poller := NewGatewayPoller(GatewayConfig{ID: "gw-handle"}, NewTTNApiService("http://localhost/stats", "test-key"), time.Hour)
+	defer deleteGatewayMetrics("gw-handle")
+
+	poller.handleEvent(Event{Name: "gs.gateway.connect"})
+	assert.Equal(t, 1.0, testutil.ToFloat64(gatewayConnected.With(poller.labels)))
+	poller.handleEvent(Event{Name: "gs.down.send"})
+	assert.Equal(t, 1.0, testutil.ToFloat64(gatewayConnected.With(poller.labels)))
+	poller.handleEvent(Event{Name: "gs.gateway.disconnect"})
+	assert.Equal(t, 0.0, testutil.ToFloat64(gatewayConnected.With(poller.labels)))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(gatewayEvents.With(eventLabels(poller, "gs.down.send"))))
+	deleteGatewayMetrics("gw-handle")
+	assert.Equal(t, 0, gatewayEvents.DeletePartialMatch(map[string]string{"gateway_id": "gw-handle"}))
+}
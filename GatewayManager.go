@@ -0,0 +1,609 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrGatewayExists   = errors.New("gateway is already monitored")
+	ErrGatewayNotFound = errors.New("gateway is not monitored")
+	// ErrInvalidGateway is matched by the errors of gateways that can't be monitored as configured
+	ErrInvalidGateway = errors.New("invalid gateway")
+)
+
+// invalidGatewayError is a mistake in the settings of a gateway, it keeps the message of err
+type invalidGatewayError struct {
+	err error
+}
+
+func (e *invalidGatewayError) Error() string {
+	return e.err.Error()
+}
+
+func (e *invalidGatewayError) Unwrap() error {
+	return e.err
+}
+
+func (e *invalidGatewayError) Is(target error) bool {
+	return target == ErrInvalidGateway
+}
+
+// invalidGateway marks err as a mistake in the settings of a gateway
+func invalidGateway(err error) error {
+	return &invalidGatewayError{err: err}
+}
+
+// GatewayManager keeps track of all monitored gateways and their pollers
+type GatewayManager struct {
+	mu              sync.Mutex
+	pollers         map[string]*GatewayPoller
+	baseUrl         string
+	urlSuffix       string
+	apiKey          string
+	interval        time.Duration
+	configFile      string
+	resolver        *ClusterResolver
+	identityServer  *IdentityServer
+	metadataTtl     time.Duration
+	labels          map[string]string
+	attributeLabels map[string]string
+	schema          *SchemaChecker
+	grpc            *GrpcClient
+	streamEvents    bool
+	// Gateways of the admin API that aren't persisted to the config file, reloads keep them
+	runtime map[string]GatewayConfig
+}
+
+// NewGatewayManager creates a manager without any gateways
+func NewGatewayManager(baseUrl string, urlSuffix string, apiKey string, interval time.Duration) *GatewayManager {
+	return &GatewayManager{
+		pollers:   make(map[string]*GatewayPoller),
+		runtime:   make(map[string]GatewayConfig),
+		baseUrl:   baseUrl,
+		urlSuffix: urlSuffix,
+		apiKey:    apiKey,
+		interval:  interval,
+	}
+}
+
+// PersistTo makes the manager write every change to the gateways list of the config file
+func (m *GatewayManager) PersistTo(configFile string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.configFile = configFile
+}
+
+// SetIdentityServer enables gateways configured by EUI and the lookup of the EUI of gateways configured by id
+func (m *GatewayManager) SetIdentityServer(identityServer *IdentityServer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.identityServer = identityServer
+}
+
+// DetectClusters makes gateways without their own base url query the gateway
+// server the resolver finds instead of the base url of the manager
+func (m *GatewayManager) DetectClusters(resolver *ClusterResolver) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resolver = resolver
+}
+
+// CheckSchema makes new gateways compare their stats with the expected schema
+func (m *GatewayManager) CheckSchema(checker *SchemaChecker) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.schema = checker
+}
+
+// UseGrpc makes new gateways fetch their stats and metadata through the gRPC API
+func (m *GatewayManager) UseGrpc(client *GrpcClient) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.grpc = client
+}
+
+// StreamEvents makes new gateways subscribe to their events of the Events API
+func (m *GatewayManager) StreamEvents() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.streamEvents = true
+}
+
+// FetchMetadata makes new gateways export their metadata of the identity server, cached for ttl
+func (m *GatewayManager) FetchMetadata(ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.metadataTtl = ttl
+}
+
+// SetApiKey changes the API key of all current and future gateways
+func (m *GatewayManager) SetApiKey(apiKey string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.apiKey = apiKey
+	for _, poller := range m.pollers {
+		if config := poller.Config(); config.ApiKey == "" && config.ApiKeyFile == "" {
+			poller.apiService.SetApiToken(apiKey)
+		}
+	}
+}
+
+// ApiKey returns the API key of gateways without their own key
+func (m *GatewayManager) ApiKey() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.apiKey
+}
+
+// ReloadApiKeyFiles reads the api key files of the gateways again and uses changed keys
+func (m *GatewayManager) ReloadApiKeyFiles() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, poller := range m.pollers {
+		path := poller.Config().ApiKeyFile
+		if path == "" {
+			continue
+		}
+		key, err := LoadApiKeyFile(path)
+		if err != nil {
+			slog.Warn("Failed to reload the api key, keeping the current one", "gateway_id", id, "path", path, "error", err)
+			continue
+		}
+		if key != poller.apiService.getApiToken() {
+			poller.apiService.SetApiToken(key)
+			slog.Info("Reloaded the TTN api key", "gateway_id", id, "path", path)
+		}
+	}
+}
+
+// WatchApiKeyFiles calls ReloadApiKeyFiles every interval until the context is done
+func (m *GatewayManager) WatchApiKeyFiles(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.ReloadApiKeyFiles()
+		}
+	}
+}
+
+// Add starts monitoring a gateway. Settings the gateway doesn't have are taken from the manager.
+func (m *GatewayManager) Add(gateway GatewayConfig) error {
+	_, err := m.addGateway(gateway, false)
+	return err
+}
+
+// AddAtRuntime starts monitoring a gateway of the admin API and returns it with the id and eui
+// of the Identity Server. Unless the changes are persisted, the config file doesn't list the
+// gateway and reloads keep it.
+func (m *GatewayManager) AddAtRuntime(gateway GatewayConfig) (GatewayConfig, error) {
+	return m.addGateway(gateway, true)
+}
+
+func (m *GatewayManager) addGateway(gateway GatewayConfig, runtime bool) (GatewayConfig, error) {
+	gateway, err := m.identify(gateway)
+	if err != nil {
+		return gateway, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for name := range gateway.Labels {
+		if !slices.Contains(customGatewayLabelNames, name) {
+			return gateway, invalidGateway(fmt.Errorf("gateway %q: label %q is not configured for any gateway at startup", gateway.name(), name))
+		}
+	}
+	if _, ok := m.pollers[gateway.ID]; ok {
+		return gateway, ErrGatewayExists
+	}
+	if err := m.add(gateway); err != nil {
+		return gateway, err
+	}
+	if runtime && m.configFile == "" {
+		m.runtime[gateway.ID] = gateway
+	}
+
+	return gateway, m.persist()
+}
+
+// add starts the poller of a gateway. The caller must hold m.mu.
+func (m *GatewayManager) add(gateway GatewayConfig) error {
+	url, apiKey, interval, err := m.resolve(gateway)
+	if err != nil {
+		return err
+	}
+
+	apiService := NewTTNApiService(url, apiKey)
+	if m.schema != nil {
+		apiService.CheckSchema(m.schema)
+	}
+	poller := NewGatewayPoller(gateway, apiService, interval)
+	poller.SetLabels(m.labels, m.attributeLabels)
+	if m.grpc != nil {
+		poller.UseGrpc(m.grpc)
+	}
+	if m.streamEvents {
+		poller.StreamEvents()
+	}
+	if m.resolver != nil && gateway.BaseUrl == "" {
+		poller.DetectCluster(m.resolver)
+	}
+	if m.identityServer != nil && m.metadataTtl > 0 {
+		poller.FetchMetadata(m.identityServerFor(gateway), m.metadataTtl)
+	}
+	if gateway.Paused {
+		poller.Pause()
+	}
+	poller.Start()
+	m.pollers[gateway.ID] = poller
+	slog.Info("Monitoring gateway", "gateway_id", gateway.ID, "paused", gateway.Paused)
+	return nil
+}
+
+// identify looks up the id of a gateway configured by EUI and the EUI of a gateway
+// configured by id in the Identity Server. The lookups are made without holding m.mu,
+// so a slow Identity Server doesn't block the other gateways.
+func (m *GatewayManager) identify(gateway GatewayConfig) (GatewayConfig, error) {
+	if err := validateGateway(gateway, nil); err != nil {
+		return gateway, invalidGateway(err)
+	}
+	gateway.EUI = normalizeEUI(gateway.EUI)
+
+	m.mu.Lock()
+	known, ok := m.known(gateway)
+	identityServer := m.identityServerFor(gateway)
+	var apiKey string
+	var err error
+	if !ok && identityServer != nil {
+		_, apiKey, _, err = m.resolve(gateway)
+	}
+	m.mu.Unlock()
+
+	if ok {
+		return known, nil
+	}
+	if identityServer == nil {
+		if gateway.ID == "" {
+			return gateway, invalidGateway(fmt.Errorf("gateway eui %q: no identity server to look up the gateway id", gateway.EUI))
+		}
+		return gateway, nil
+	}
+	if err != nil {
+		return gateway, err
+	}
+
+	if gateway.ID == "" {
+		id, err := identityServer.GatewayIdForEUI(gateway.EUI, apiKey)
+		if errors.Is(err, ErrUnknownEUI) {
+			return gateway, invalidGateway(fmt.Errorf("gateway eui %q: %w", gateway.EUI, err))
+		}
+		if err != nil {
+			return gateway, fmt.Errorf("gateway eui %q: %w", gateway.EUI, err)
+		}
+		slog.Info("Found the gateway of the eui", "gateway_eui", gateway.EUI, "gateway_id", id)
+		gateway.ID = id
+	} else if gateway.EUI == "" {
+		eui, err := identityServer.GatewayEUI(gateway.ID, apiKey)
+		if err != nil {
+			// The gateway can still be monitored, just without the eui label
+			slog.Warn("Failed to look up the eui of the gateway", "gateway_id", gateway.ID, "error", err)
+		}
+		gateway.EUI = eui
+	}
+	return gateway, nil
+}
+
+// identityServerFor returns the Identity Server of the gateway. Gateways with their own base url,
+// like the ones of another tenant, are looked up on its host instead of the one of the manager.
+// The caller must hold m.mu.
+func (m *GatewayManager) identityServerFor(gateway GatewayConfig) *IdentityServer {
+	if m.identityServer == nil || gateway.BaseUrl == "" {
+		return m.identityServer
+	}
+	identityServer, err := NewIdentityServer(gateway.BaseUrl)
+	if err != nil {
+		return m.identityServer
+	}
+	return identityServer
+}
+
+// known returns the gateway with the id and eui of the monitored gateway it matches.
+// The caller must hold m.mu.
+func (m *GatewayManager) known(gateway GatewayConfig) (GatewayConfig, bool) {
+	for id, poller := range m.pollers {
+		known := poller.Config()
+		if gateway.ID == id && (gateway.EUI == "" || gateway.EUI == known.EUI) {
+			gateway.EUI = known.EUI
+			return gateway, true
+		}
+		if gateway.ID == "" && gateway.EUI == known.EUI {
+			gateway.ID = id
+			return gateway, true
+		}
+	}
+	return gateway, false
+}
+
+// resolve returns the stats url, api key and poll interval of a gateway,
+// using the settings of the manager for everything the gateway doesn't set
+func (m *GatewayManager) resolve(gateway GatewayConfig) (url string, apiKey string, interval time.Duration, err error) {
+	baseUrl := gatewayServerUrl(m.baseUrl)
+	if gateway.BaseUrl != "" {
+		baseUrl = gatewayServerUrl(gateway.BaseUrl)
+	}
+	apiKey = m.apiKey
+	if gateway.ApiKey != "" {
+		apiKey = gateway.ApiKey
+	}
+	if gateway.ApiKeyFile != "" {
+		apiKey, err = LoadApiKeyFile(gateway.ApiKeyFile)
+		if err != nil {
+			return "", "", 0, fmt.Errorf("gateway %q: %w", gateway.name(), err)
+		}
+	}
+	if apiKey == "" {
+		return "", "", 0, invalidGateway(fmt.Errorf("gateway %q: no api key configured", gateway.name()))
+	}
+	interval = m.interval
+	if gateway.ReadInterval > 0 {
+		interval = time.Duration(gateway.ReadInterval) * time.Second
+	}
+	return baseUrl + gateway.ID + m.urlSuffix, apiKey, interval, nil
+}
+
+// Remove stops monitoring a gateway and deletes all of its series
+func (m *GatewayManager) Remove(gatewayId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.pollers[gatewayId]; !ok {
+		return ErrGatewayNotFound
+	}
+	m.remove(gatewayId)
+	delete(m.runtime, gatewayId)
+
+	return m.persist()
+}
+
+// remove stops the poller of a gateway and deletes its series. The caller must hold m.mu.
+func (m *GatewayManager) remove(gatewayId string) {
+	m.pollers[gatewayId].Stop()
+	delete(m.pollers, gatewayId)
+	deleteGatewayMetrics(gatewayId)
+	antennaLocations.delete(gatewayId)
+	monitoredGatewayLabels.delete(gatewayId)
+	slog.Info("Stopped monitoring gateway", "gateway_id", gatewayId)
+}
+
+// Configure changes the settings gateways fall back to. Running pollers are
+// not touched, call Apply to bring them in line with the new settings.
+func (m *GatewayManager) Configure(baseUrl string, urlSuffix string, apiKey string, interval time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.baseUrl = baseUrl
+	m.urlSuffix = urlSuffix
+	m.apiKey = apiKey
+	m.interval = interval
+}
+
+// SetLabelNames re-creates the gateway metrics with other custom label names.
+// All pollers are stopped because their series no longer fit, Apply starts them again.
+func (m *GatewayManager) SetLabelNames(labelNames []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if slices.Equal(labelNames, customGatewayLabelNames) {
+		return
+	}
+	for _, poller := range m.pollers {
+		poller.Stop()
+	}
+	m.pollers = make(map[string]*GatewayPoller)
+	initGatewayMetrics(labelNames)
+	slog.Info("Changed the custom gateway labels", "labels", labelNames)
+}
+
+// SetLabels changes the custom labels of all gateways and the custom labels taken from the
+// attributes of the gateways. All pollers are stopped and their series with the old label
+// values deleted if they changed, Apply starts them again.
+func (m *GatewayManager) SetLabels(labels map[string]string, attributeLabels map[string]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if maps.Equal(labels, m.labels) && maps.Equal(attributeLabels, m.attributeLabels) {
+		return
+	}
+	for id, poller := range m.pollers {
+		poller.Stop()
+		deleteGatewayMetrics(id)
+	}
+	m.pollers = make(map[string]*GatewayPoller)
+	m.labels = labels
+	m.attributeLabels = attributeLabels
+}
+
+// Apply brings the monitored gateways in line with the list. New gateways are
+// started and missing ones stopped. Gateways with another url, labels or metrics
+// are restarted, all others keep running with the new interval, key and paused state.
+// Gateways added at runtime are kept unless the list has them as well.
+// Gateways that fail are skipped, their errors are joined.
+func (m *GatewayManager) Apply(gateways []GatewayConfig) error {
+	var errs []error
+	identified := make([]GatewayConfig, 0, len(gateways))
+	wanted := make(map[string]bool, len(gateways))
+	for _, gateway := range gateways {
+		gateway, err := m.identify(gateway)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		identified = append(identified, gateway)
+		wanted[gateway.ID] = true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id := range m.pollers {
+		if _, runtime := m.runtime[id]; !wanted[id] && !runtime {
+			m.remove(id)
+		}
+	}
+
+	for _, gateway := range identified {
+		delete(m.runtime, gateway.ID)
+		if err := m.apply(gateway); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Gateways of the admin API are started again if the pollers were reset
+	var kept []string
+	for id, gateway := range m.runtime {
+		kept = append(kept, id)
+		if _, ok := m.pollers[id]; ok {
+			continue
+		}
+		if err := m.add(gateway); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(kept) > 0 {
+		sort.Strings(kept)
+		slog.Info("Kept the gateways added through the admin API, they aren't in the config file", "gateways", kept)
+	}
+	return errors.Join(errs...)
+}
+
+// apply starts, updates or restarts the poller of an identified gateway. The caller must hold m.mu.
+func (m *GatewayManager) apply(gateway GatewayConfig) error {
+	poller, ok := m.pollers[gateway.ID]
+	if !ok {
+		return m.add(gateway)
+	}
+
+	url, apiKey, interval, err := m.resolve(gateway)
+	if err != nil {
+		return err
+	}
+	if url != poller.url || !sameSeries(poller.Config(), gateway) {
+		m.remove(gateway.ID)
+		return m.add(gateway)
+	}
+
+	if apiKey != poller.apiService.getApiToken() {
+		poller.apiService.SetApiToken(apiKey)
+	}
+
+	if interval != poller.Interval() {
+		slog.Info("Changed the poll interval", "gateway_id", gateway.ID, "interval", interval)
+	}
+	poller.Update(gateway, interval)
+	if gateway.Paused != poller.IsPaused() {
+		if gateway.Paused {
+			poller.Pause()
+		} else {
+			poller.Resume()
+		}
+		slog.Info("Changed the paused state", "gateway_id", gateway.ID, "paused", gateway.Paused)
+	}
+	return nil
+}
+
+// sameSeries reports whether both configs produce the same series
+func sameSeries(a GatewayConfig, b GatewayConfig) bool {
+	return a.EUI == b.EUI && a.Cluster == b.Cluster && a.Tenant == b.Tenant && maps.Equal(a.Labels, b.Labels) && slices.Equal(a.Metrics, b.Metrics)
+}
+
+// Pause keeps the gateway configured but stops polling it
+func (m *GatewayManager) Pause(gatewayId string) error {
+	return m.setPaused(gatewayId, true)
+}
+
+// Resume continues polling a paused gateway
+func (m *GatewayManager) Resume(gatewayId string) error {
+	return m.setPaused(gatewayId, false)
+}
+
+func (m *GatewayManager) setPaused(gatewayId string, paused bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	poller, ok := m.pollers[gatewayId]
+	if !ok {
+		return ErrGatewayNotFound
+	}
+
+	if gateway, ok := m.runtime[gatewayId]; ok {
+		gateway.Paused = paused
+		m.runtime[gatewayId] = gateway
+	}
+	if paused {
+		poller.Pause()
+		slog.Info("Paused gateway", "gateway_id", gatewayId)
+	} else {
+		poller.Resume()
+		slog.Info("Resumed gateway", "gateway_id", gatewayId)
+	}
+
+	return m.persist()
+}
+
+// List returns all monitored gateways sorted by id
+func (m *GatewayManager) List() []GatewayConfig {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.list()
+}
+
+func (m *GatewayManager) list() []GatewayConfig {
+	gateways := make([]GatewayConfig, 0, len(m.pollers))
+	for _, poller := range m.pollers {
+		gateway := poller.Config()
+		gateway.Paused = poller.IsPaused()
+		gateways = append(gateways, gateway)
+	}
+	sort.Slice(gateways, func(i, j int) bool {
+		return gateways[i].ID < gateways[j].ID
+	})
+	return gateways
+}
+
+// StopAll stops all pollers, e.g. on shutdown
+func (m *GatewayManager) StopAll() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, poller := range m.pollers {
+		poller.Stop()
+	}
+	m.pollers = make(map[string]*GatewayPoller)
+}
+
+// persist writes the current gateways to the config file. The caller must hold m.mu.
+// The in-memory change stays applied even if writing the file fails.
+func (m *GatewayManager) persist() error {
+	if m.configFile == "" {
+		return nil
+	}
+	if err := SaveGatewaysToConfigFile(m.configFile, m.list()); err != nil {
+		return fmt.Errorf("persisting gateways: %w", err)
+	}
+	return nil
+}
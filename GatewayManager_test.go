@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMockStatsServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(GatewayStats{
+			UplinkCount: NewUint64String(42),
+			RoundTripTimes: RoundTripTimes{
+				Min:    NewProtoDuration(10 * time.Millisecond),
+				Max:    NewProtoDuration(100 * time.Millisecond),
+				Median: NewProtoDuration(50 * time.Millisecond),
+				Count:  NewUint64String(7),
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGatewayManager_AddRemove(t *testing.T) {
+	server := newMockStatsServer(t)
+	manager := NewGatewayManager(server.URL+"/", "/connection/stats", "test-key", time.Hour)
+	defer manager.StopAll()
+
+	// Gateways are added paused so the tests don't touch the global api call counters
+	t.Run("Add gateway", func(t *testing.T) {
+		err := manager.Add(GatewayConfig{ID: "gw-add-remove", Paused: true})
+		assert.Nil(t, err)
+		assert.Equal(t, []GatewayConfig{{ID: "gw-add-remove", Paused: true}}, manager.List())
+	})
+
+	t.Run("Add gateway twice", func(t *testing.T) {
+		err := manager.Add(GatewayConfig{ID: "gw-add-remove", Paused: true})
+		assert.ErrorIs(t, err, ErrGatewayExists)
+	})
+
+	t.Run("Add gateway without id", func(t *testing.T) {
+		err := manager.Add(GatewayConfig{})
+		assert.EqualError(t, err, "gateway id or eui must not be empty")
+	})
+
+	t.Run("Remove gateway deletes its series", func(t *testing.T) {
+		// Metrics with more labels than the gateway labels are set by their helpers
+		withMoreLabels := append(gatewayLocationVecs(), gatewayMetadataInfo, gatewayAttributeInfo, gatewayLocationDrift, roundTripTime)
+		for _, vec := range gatewayMetricVecs() {
+			if !slices.Contains(withMoreLabels, vec) {
+				vec.WithLabelValues("gw-add-remove", "", "eu1", "").Set(1)
+			}
+		}
+		labels := prometheus.Labels{"gateway_id": "gw-add-remove", "gateway_eui": "", "cluster": "eu1", "tenant": ""}
+		setRoundTripTime(labels, "0.5", 2)
+		metadata := GatewayMetadata{Name: "Add remove", Attributes: map[string]string{"site": "berlin"}}
+		metadata.Antennas = make([]GatewayAntenna, 1)
+		setGatewayMetadataMetrics(labels, metadata)
+		setGatewayLocationMetrics(labels, metadata.Antennas)
+		numberOfUplinkMessages.WithLabelValues("gw-other", "", "eu1", "").Set(1)
+
+		err := manager.Remove("gw-add-remove")
+		assert.Nil(t, err)
+		assert.Empty(t, manager.List())
+
+		for _, vec := range gatewayMetricVecs() {
+			assert.Zero(t, vec.DeletePartialMatch(prometheus.Labels{"gateway_id": "gw-add-remove"}))
+		}
+		assert.True(t, numberOfUplinkMessages.DeleteLabelValues("gw-other", "", "eu1", ""))
+	})
+
+	t.Run("Remove unknown gateway", func(t *testing.T) {
+		err := manager.Remove("unknown")
+		assert.ErrorIs(t, err, ErrGatewayNotFound)
+	})
+}
+
+func TestGatewayManager_PauseResume(t *testing.T) {
+	server := newMockStatsServer(t)
+	manager := NewGatewayManager(server.URL+"/", "/connection/stats", "test-key", time.Hour)
+	defer manager.StopAll()
+
+	assert.Nil(t, manager.Add(GatewayConfig{ID: "gw-pause", Paused: true}))
+	assert.Equal(t, []GatewayConfig{{ID: "gw-pause", Paused: true}}, manager.List())
+
+	assert.Nil(t, manager.Resume("gw-pause"))
+	assert.Equal(t, []GatewayConfig{{ID: "gw-pause"}}, manager.List())
+
+	assert.Nil(t, manager.Pause("gw-pause"))
+	assert.Equal(t, []GatewayConfig{{ID: "gw-pause", Paused: true}}, manager.List())
+
+	assert.ErrorIs(t, manager.Pause("unknown"), ErrGatewayNotFound)
+	assert.ErrorIs(t, manager.Resume("unknown"), ErrGatewayNotFound)
+}
+
+func TestGatewayManager_Persistence(t *testing.T) {
+	server := newMockStatsServer(t)
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(configFile, []byte("# my gateways\nread_interval: 300\ngateways:\n  - id: old\n"), 0o644)
+
+	manager := NewGatewayManager(server.URL+"/", "/connection/stats", "test-key", time.Hour)
+	defer manager.StopAll()
+	manager.PersistTo(configFile)
+
+	assert.Nil(t, manager.Add(GatewayConfig{ID: "gw-b", Paused: true}))
+	assert.Nil(t, manager.Add(GatewayConfig{ID: "gw-a", Paused: true}))
+	assert.Nil(t, manager.Resume("gw-a"))
+
+	config, err := LoadConfigFile(configFile)
+	assert.Nil(t, err)
+	assert.Equal(t, []GatewayConfig{{ID: "gw-a"}, {ID: "gw-b", Paused: true}}, config.Gateways)
+
+	// Comments and unrelated keys survive
+	data, _ := os.ReadFile(configFile)
+	assert.Contains(t, string(data), "# my gateways")
+	assert.Contains(t, string(data), "read_interval: 300")
+
+	assert.Nil(t, manager.Remove("gw-b"))
+	config, err = LoadConfigFile(configFile)
+	assert.Nil(t, err)
+	assert.Equal(t, []GatewayConfig{{ID: "gw-a"}}, config.Gateways)
+}
+
+func TestGatewayManager_AddUnknownLabel(t *testing.T) {
+	manager := NewGatewayManager("http://localhost/", "/connection/stats", "test-key", time.Hour)
+	defer manager.StopAll()
+
+	err := manager.Add(GatewayConfig{ID: "gw-labels", Paused: true, Labels: map[string]string{"site": "berlin"}})
+	assert.EqualError(t, err, "gateway \"gw-labels\": label \"site\" is not configured for any gateway at startup")
+}
+
+func TestGatewayManager_SetLabels(t *testing.T) {
+	initGatewayMetrics([]string{"customer"})
+	t.Cleanup(func() { initGatewayMetrics(nil) })
+
+	server := newMockStatsServer(t)
+	manager := NewGatewayManager(server.URL+"/", "/connection/stats", "test-key", time.Hour)
+	defer manager.StopAll()
+	manager.SetLabels(map[string]string{"customer": "acme"}, nil)
+	assert.Nil(t, manager.Apply([]GatewayConfig{{ID: "gw-set-labels", Paused: true}}))
+	gatewayConnected.With(manager.pollers["gw-set-labels"].labels).Set(1)
+
+	// The series with the old customer are deleted before the gateway is started again
+	manager.SetLabels(map[string]string{"customer": "other"}, nil)
+	assert.Nil(t, manager.Apply([]GatewayConfig{{ID: "gw-set-labels", Paused: true}}))
+	assert.Equal(t, "other", manager.pollers["gw-set-labels"].labels["customer"])
+	assert.Zero(t, gatewayConnected.DeletePartialMatch(prometheus.Labels{"customer": "acme"}))
+}
+
+func TestGatewayManager_PerGatewayCluster(t *testing.T) {
+	manager := NewGatewayManager("https://eu1.cloud.thethings.network/api/v3/gs/gateways/", "/connection/stats", "test-key", time.Hour)
+	defer manager.StopAll()
+
+	assert.Nil(t, manager.Add(GatewayConfig{ID: "gw-eu1", Paused: true}))
+	assert.Nil(t, manager.Add(GatewayConfig{ID: "gw-nam1", Paused: true, BaseUrl: "https://nam1.cloud.thethings.network", ApiKey: "nam1-key"}))
+
+	eu1 := manager.pollers["gw-eu1"]
+	assert.Equal(t, "https://eu1.cloud.thethings.network/api/v3/gs/gateways/gw-eu1/connection/stats", eu1.apiService.url)
+	assert.Equal(t, "eu1", eu1.labels["cluster"])
+
+	nam1 := manager.pollers["gw-nam1"]
+	assert.Equal(t, "https://nam1.cloud.thethings.network/api/v3/gs/gateways/gw-nam1/connection/stats", nam1.apiService.url)
+	assert.Equal(t, "nam1-key", nam1.apiService.getApiToken())
+	assert.Equal(t, "nam1", nam1.labels["cluster"])
+}
+
+func TestGatewayManager_IdentityServerOfBaseUrl(t *testing.T) {
+	// The Identity Server of the manager knows no gateway
+	other := httptest.NewServer(http.NotFoundHandler())
+	defer other.Close()
+	identityServer, err := NewIdentityServer(other.URL)
+	assert.Nil(t, err)
+	registry := newMockGatewayRegistry(t)
+
+	manager := NewGatewayManager(other.URL+"/api/v3/gs/gateways/", "/connection/stats", "test-key", time.Hour)
+	defer manager.StopAll()
+	manager.SetIdentityServer(identityServer)
+	manager.FetchMetadata(time.Hour)
+
+	assert.Nil(t, manager.Add(GatewayConfig{EUI: "58A0CBFFFE800001", BaseUrl: registry.URL, Paused: true}))
+	assert.Nil(t, manager.Add(GatewayConfig{ID: "gw-by-id", BaseUrl: registry.URL, Paused: true}))
+	assert.Equal(t, "58A0CBFFFE800001", manager.pollers["gw-by-eui"].labels["gateway_eui"])
+	assert.Equal(t, "58A0CBFFFE800002", manager.pollers["gw-by-id"].labels["gateway_eui"])
+	// The metadata is read from the same Identity Server
+	assert.Equal(t, registry.URL, manager.pollers["gw-by-id"].identityServer.url.String())
+}
+
+func TestGatewayManager_IdentifyWithoutLock(t *testing.T) {
+	lookup, release := make(chan struct{}), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(lookup)
+		<-release
+		json.NewEncoder(w).Encode(map[string]string{"gateway_id": "gw-slow"})
+	}))
+	defer server.Close()
+	identityServer, err := NewIdentityServer(server.URL)
+	assert.Nil(t, err)
+
+	manager := NewGatewayManager(server.URL+"/api/v3/gs/gateways/", "/connection/stats", "test-key", time.Hour)
+	defer manager.StopAll()
+	manager.SetIdentityServer(identityServer)
+
+	added := make(chan error)
+	go func() { added <- manager.Add(GatewayConfig{EUI: "58A0CBFFFE800003", Paused: true}) }()
+	<-lookup
+
+	// The other gateways can be managed while the Identity Server is looked up
+	listed := make(chan []GatewayConfig)
+	go func() { listed <- manager.List() }()
+	select {
+	case gateways := <-listed:
+		assert.Empty(t, gateways)
+	case <-time.After(5 * time.Second):
+		close(release)
+		t.Fatal("List waited for the lookup in the Identity Server")
+	}
+
+	close(release)
+	assert.Nil(t, <-added)
+	assert.Equal(t, []GatewayConfig{{ID: "gw-slow", EUI: "58A0CBFFFE800003", Paused: true}}, manager.List())
+}
+
+func TestGatewayManager_GatewayEUI(t *testing.T) {
+	server := newMockGatewayRegistry(t)
+	identityServer, err := NewIdentityServer(server.URL)
+	assert.Nil(t, err)
+
+	manager := NewGatewayManager(server.URL+"/api/v3/gs/gateways/", "/connection/stats", "test-key", time.Hour)
+	defer manager.StopAll()
+
+	t.Run("Eui without identity server", func(t *testing.T) {
+		err := manager.Add(GatewayConfig{EUI: "58A0CBFFFE800001", Paused: true})
+		assert.EqualError(t, err, `gateway eui "58A0CBFFFE800001": no identity server to look up the gateway id`)
+	})
+
+	manager.SetIdentityServer(identityServer)
+
+	t.Run("Add by eui", func(t *testing.T) {
+		assert.Nil(t, manager.Add(GatewayConfig{EUI: "58-a0-cb-ff-fe-80-00-01", Paused: true}))
+		poller := manager.pollers["gw-by-eui"]
+		assert.Equal(t, "58A0CBFFFE800001", poller.labels["gateway_eui"])
+		assert.Equal(t, server.URL+"/api/v3/gs/gateways/gw-by-eui/connection/stats", poller.url)
+	})
+
+	t.Run("Add by id looks up the eui", func(t *testing.T) {
+		assert.Nil(t, manager.Add(GatewayConfig{ID: "gw-by-id", Paused: true}))
+		assert.Equal(t, "58A0CBFFFE800002", manager.pollers["gw-by-id"].labels["gateway_eui"])
+	})
+
+	t.Run("Unknown eui", func(t *testing.T) {
+		err := manager.Add(GatewayConfig{EUI: "58A0CBFFFE800009", Paused: true})
+		assert.EqualError(t, err, `gateway eui "58A0CBFFFE800009": no gateway is registered with the eui`)
+		assert.ErrorIs(t, err, ErrInvalidGateway)
+	})
+
+	t.Run("Apply keeps gateways known by eui", func(t *testing.T) {
+		poller := manager.pollers["gw-by-eui"]
+		assert.Nil(t, manager.Apply([]GatewayConfig{{EUI: "58A0CBFFFE800001", Paused: true}}))
+		assert.Same(t, poller, manager.pollers["gw-by-eui"])
+		assert.Equal(t, []GatewayConfig{{ID: "gw-by-eui", EUI: "58A0CBFFFE800001", Paused: true}}, manager.List())
+	})
+}
+
+func TestGatewayManager_UplinkLabels(t *testing.T) {
+	t.Cleanup(resetUplinkMetrics)
+	manager := NewGatewayManager("https://eu1.cloud.thethings.network/api/v3/gs/gateways/", "/connection/stats", "test-key", time.Hour)
+	defer manager.StopAll()
+	assert.Nil(t, manager.Add(GatewayConfig{ID: "gw-uplink-labels", EUI: "58A0CBFFFE800004", Tenant: "acme", Paused: true}))
+
+	// The uplinks of monitored gateways have the labels of the polled stats, the others only their id
+	var uplink ApplicationUplink
+	assert.Nil(t, json.Unmarshal(mqttUplink("dev-1", "gw-uplink-labels", -80), &uplink))
+	observeUplink(uplink)
+	assert.Nil(t, json.Unmarshal(mqttUplink("dev-1", "gw-other-network", -90), &uplink))
+	observeUplink(uplink)
+	heardDevices.update(time.Now())
+	assert.Equal(t, 1.0, testutil.ToFloat64(uplinksReceived.WithLabelValues("gw-uplink-labels", "58A0CBFFFE800004", "eu1", "acme")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(uplinkDevices.WithLabelValues("gw-uplink-labels", "58A0CBFFFE800004", "eu1", "acme")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(uplinksReceived.WithLabelValues("gw-other-network", "", "", "")))
+
+	// Removing the gateway deletes the series of its uplinks
+	assert.Nil(t, manager.Remove("gw-uplink-labels"))
+	for _, vec := range gatewayUplinkVecs() {
+		assert.Zero(t, vec.DeletePartialMatch(prometheus.Labels{"gateway_id": "gw-uplink-labels"}))
+	}
+	assert.Equal(t, 1, testutil.CollectAndCount(uplinksReceived))
+	assert.Equal(t, 1, testutil.CollectAndCount(uplinkDevices))
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Labels of gw_metadata_info, they can't be used as custom labels
+var metadataLabelNames = []string{
+	"name",
+	"description",
+	"brand_id",
+	"model_id",
+	"frequency_plan_ids",
+	"status_public",
+	"auto_update",
+	"update_channel",
+	"enforce_duty_cycle",
+}
+
+// Labels that tell apart the series of the attributes and antennas of a gateway
+var seriesLabelNames = []string{"key", "value", "antenna", "source"}
+
+// GatewayAntenna is an antenna of the gateway entity, the location is nil if it isn't set
+type GatewayAntenna struct {
+	Gain     float64   `json:"gain"`
+	Location *Location `json:"location"`
+}
+
+// GatewayMetadata is the gateway entity of the Identity Server
+type GatewayMetadata struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	VersionIds  struct {
+		BrandId string `json:"brand_id"`
+		ModelId string `json:"model_id"`
+	} `json:"version_ids"`
+	FrequencyPlanIds []string          `json:"frequency_plan_ids"`
+	StatusPublic     bool              `json:"status_public"`
+	AutoUpdate       bool              `json:"auto_update"`
+	UpdateChannel    string            `json:"update_channel"`
+	EnforceDutyCycle bool              `json:"enforce_duty_cycle"`
+	Attributes       map[string]string `json:"attributes"`
+	Antennas         []GatewayAntenna  `json:"antennas"`
+}
+
+var metadataFieldMask = "name,description,version_ids,frequency_plan_ids,status_public,auto_update,update_channel,enforce_duty_cycle,attributes,antennas"
+
+// GatewayMetadata returns the name, versions, settings, attributes and antennas of a gateway
+func (is *IdentityServer) GatewayMetadata(gatewayId string, apiKey string) (GatewayMetadata, error) {
+	var metadata GatewayMetadata
+	if err := is.service(apiKey, metadataFieldMask, "gateways", gatewayId).GetJson(&metadata); err != nil {
+		return GatewayMetadata{}, fmt.Errorf("looking up the gateway metadata: %w", err)
+	}
+	return metadata, nil
+}
+
+// labels returns the values of the metadata labels
+func (metadata GatewayMetadata) labels() prometheus.Labels {
+	return prometheus.Labels{
+		"name":               metadata.Name,
+		"description":        metadata.Description,
+		"brand_id":           metadata.VersionIds.BrandId,
+		"model_id":           metadata.VersionIds.ModelId,
+		"frequency_plan_ids": strings.Join(metadata.FrequencyPlanIds, ","),
+		"status_public":      strconv.FormatBool(metadata.StatusPublic),
+		"auto_update":        strconv.FormatBool(metadata.AutoUpdate),
+		"update_channel":     metadata.UpdateChannel,
+		"enforce_duty_cycle": strconv.FormatBool(metadata.EnforceDutyCycle),
+	}
+}
+
+// setGatewayMetadataMetrics replaces the metadata series of a gateway
+func setGatewayMetadataMetrics(gatewayLabels prometheus.Labels, metadata GatewayMetadata) {
+	match := prometheus.Labels{"gateway_id": gatewayLabels["gateway_id"]}
+	gatewayMetadataInfo.DeletePartialMatch(match)
+	gatewayAttributeInfo.DeletePartialMatch(match)
+
+	labels := metadata.labels()
+	for name, value := range gatewayLabels {
+		labels[name] = value
+	}
+	gatewayMetadataInfo.With(labels).Set(1)
+
+	for key, value := range metadata.Attributes {
+		labels := prometheus.Labels{"key": key, "value": value}
+		for name, value := range gatewayLabels {
+			labels[name] = value
+		}
+		gatewayAttributeInfo.With(labels).Set(1)
+	}
+}
+
+// setGatewayLocationMetrics replaces the antenna series of a gateway.
+// The location metrics are left out for antennas without a location.
+func setGatewayLocationMetrics(gatewayLabels prometheus.Labels, antennas []GatewayAntenna) {
+	match := prometheus.Labels{"gateway_id": gatewayLabels["gateway_id"]}
+	for _, vec := range gatewayLocationVecs() {
+		vec.DeletePartialMatch(match)
+	}
+
+	for i, antenna := range antennas {
+		labels := maps.Clone(gatewayLabels)
+		labels["antenna"] = strconv.Itoa(i)
+		gatewayAntennaGain.With(labels).Set(antenna.Gain)
+
+		if antenna.Location == nil {
+			continue
+		}
+		labels["source"] = antenna.Location.Source
+		gatewayLatitude.With(labels).Set(antenna.Location.Latitude)
+		gatewayLongitude.With(labels).Set(antenna.Location.Longitude)
+		gatewayAltitude.With(labels).Set(antenna.Location.Altitude)
+		gatewayLocationAccuracy.With(labels).Set(antenna.Location.Accuracy)
+	}
+}
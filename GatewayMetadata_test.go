@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentityServer_GatewayMetadata(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v3/gateways/gw-meta", r.URL.Path)
+		assert.Equal(t, metadataFieldMask, r.URL.Query().Get("field_mask"))
+		json.NewEncoder(w).Encode(map[string]any{
+			"ids":                map[string]string{"gateway_id": "gw-meta"},
+			"name":               "Rooftop",
+			"version_ids":        map[string]string{"brand_id": "the-things-industries", "model_id": "the-things-kickstarter-gateway"},
+			"frequency_plan_ids": []string{"EU_863_870", "EU_863_870_TTN"},
+			"status_public":      true,
+			"update_channel":     "stable",
+			"enforce_duty_cycle": true,
+			"attributes":         map[string]string{"site": "berlin"},
+			"antennas": []map[string]any{{
+				"gain":     3.5,
+				"location": map[string]any{"latitude": 52.52, "longitude": 13.405, "altitude": 40, "source": "SOURCE_REGISTRY"},
+			}},
+		})
+	}))
+	defer server.Close()
+
+	identityServer, err := NewIdentityServer(server.URL)
+	assert.Nil(t, err)
+
+	metadata, err := identityServer.GatewayMetadata("gw-meta", "test-key")
+	assert.Nil(t, err)
+	assert.Equal(t, prometheus.Labels{
+		"name":               "Rooftop",
+		"description":        "",
+		"brand_id":           "the-things-industries",
+		"model_id":           "the-things-kickstarter-gateway",
+		"frequency_plan_ids": "EU_863_870,EU_863_870_TTN",
+		"status_public":      "true",
+		"auto_update":        "false",
+		"update_channel":     "stable",
+		"enforce_duty_cycle": "true",
+	}, metadata.labels())
+	assert.Equal(t, map[string]string{"site": "berlin"}, metadata.Attributes)
+	assert.Equal(t, []GatewayAntenna{{
+		Gain:     3.5,
+		Location: &Location{Latitude: 52.52, Longitude: 13.405, Altitude: 40, Source: "SOURCE_REGISTRY"},
+	}}, metadata.Antennas)
+}
+
+func TestSetGatewayMetadataMetrics(t *testing.T) {
+	labels := prometheus.Labels{"gateway_id": "gw-meta", "gateway_eui": "", "cluster": "eu1", "tenant": ""}
+	defer deleteGatewayMetrics("gw-meta")
+
+	setGatewayMetadataMetrics(labels, GatewayMetadata{Name: "Old", Attributes: map[string]string{"site": "berlin", "rack": "r1"}})
+	setGatewayMetadataMetrics(labels, GatewayMetadata{Name: "New", Attributes: map[string]string{"site": "hamburg"}})
+
+	// Only the series of the latest metadata are left
+	assert.Equal(t, 1, testutil.CollectAndCount(gatewayMetadataInfo))
+	assert.Equal(t, 1, testutil.CollectAndCount(gatewayAttributeInfo))
+	assert.Equal(t, 1.0, testutil.ToFloat64(gatewayAttributeInfo.With(prometheus.Labels{
+		"gateway_id": "gw-meta", "gateway_eui": "", "cluster": "eu1", "tenant": "", "key": "site", "value": "hamburg",
+	})))
+}
+
+func TestSetGatewayLocationMetrics(t *testing.T) {
+	labels := prometheus.Labels{"gateway_id": "gw-location", "gateway_eui": "", "cluster": "eu1", "tenant": ""}
+	defer deleteGatewayMetrics("gw-location")
+
+	setGatewayLocationMetrics(labels, []GatewayAntenna{
+		{Gain: 3, Location: &Location{Latitude: 52.52, Longitude: 13.405, Source: "SOURCE_GPS"}},
+		{Gain: 6},
+	})
+	assert.Equal(t, 2, testutil.CollectAndCount(gatewayAntennaGain))
+	assert.Equal(t, 1, testutil.CollectAndCount(gatewayLatitude))
+
+	// A moved antenna replaces the old series
+	setGatewayLocationMetrics(labels, []GatewayAntenna{
+		{Gain: 3, Location: &Location{Latitude: 48.137, Longitude: 11.575, Source: "SOURCE_REGISTRY"}},
+	})
+	assert.Equal(t, 1, testutil.CollectAndCount(gatewayAntennaGain))
+	assert.Equal(t, 48.137, testutil.ToFloat64(gatewayLatitude.With(prometheus.Labels{
+		"gateway_id": "gw-location", "gateway_eui": "", "cluster": "eu1", "tenant": "", "antenna": "0", "source": "SOURCE_REGISTRY",
+	})))
+}
+
+func TestGatewayPoller_AttributeLabels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"attributes": map[string]string{"region": "north"}})
+	}))
+	defer server.Close()
+	identityServer, err := NewIdentityServer(server.URL)
+	assert.Nil(t, err)
+
+	initGatewayMetrics([]string{"region", "site"})
+	t.Cleanup(func() { initGatewayMetrics(nil) })
+	defer deleteGatewayMetrics("gw-attributes")
+
+	poller := NewGatewayPoller(GatewayConfig{ID: "gw-attributes", Labels: map[string]string{"site": "own"}}, NewTTNApiService(server.URL+"/stats", "test-key"), time.Hour)
+	poller.FetchMetadata(identityServer, time.Hour)
+	poller.SetLabels(map[string]string{"region": "unknown", "site": "default"}, map[string]string{"region": "region"})
+
+	// The labels of all gateways are used until the attributes are known
+	assert.Equal(t, "unknown", poller.labels["region"])
+	assert.Equal(t, "own", poller.labels["site"])
+
+	poller.updateMetadata(poller.Config())
+	assert.Equal(t, "north", poller.labels["region"])
+	assert.Equal(t, "own", poller.labels["site"])
+}
+
+func TestGatewayPoller_MetadataFailure(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+	identityServer, err := NewIdentityServer(server.URL)
+	assert.Nil(t, err)
+	defer deleteGatewayMetrics("gw-metadata-failure")
+
+	poller := NewGatewayPoller(GatewayConfig{ID: "gw-metadata-failure"}, NewTTNApiService(server.URL+"/stats", "test-key"), time.Hour)
+	poller.FetchMetadata(identityServer, time.Hour)
+
+	// A failed fetch isn't repeated on every poll but after the ttl
+	poller.updateMetadata(poller.Config())
+	poller.updateMetadata(poller.Config())
+	assert.Equal(t, 1, requests)
+	assert.Equal(t, 0, testutil.CollectAndCount(gatewayMetadataInfo))
+}
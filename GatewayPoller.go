@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// GatewayPoller periodically fetches the stats of a single gateway
+type GatewayPoller struct {
+	gatewayId       string
+	mu              sync.Mutex
+	config          GatewayConfig
+	labels          prometheus.Labels
+	url             string
+	apiService      *TTNApiService
+	resolver        *ClusterResolver
+	identityServer  *IdentityServer
+	grpc            *GrpcClient
+	metadataTtl     time.Duration
+	metadataUpdated time.Time
+	antennas        []GatewayAntenna
+	staticLabels    map[string]string
+	attributeLabels map[string]string
+	attributes      map[string]string
+	events          chan Event
+	interval        time.Duration
+	intervalChanged chan time.Duration
+	paused          atomic.Bool
+	stop            chan struct{}
+	done            chan struct{}
+}
+
+// NewGatewayPoller creates a poller for the given gateway
+func NewGatewayPoller(config GatewayConfig, apiService *TTNApiService, interval time.Duration) *GatewayPoller {
+	return &GatewayPoller{
+		gatewayId:       config.ID,
+		config:          config,
+		labels:          gatewayMetricLabels(config, apiService.url, nil),
+		url:             apiService.url,
+		apiService:      apiService,
+		interval:        interval,
+		intervalChanged: make(chan time.Duration, 1),
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+}
+
+// Start launches the polling loop in the background
+func (p *GatewayPoller) Start() {
+	monitoredGatewayLabels.set(p.gatewayId, p.labels)
+	go p.run(!p.IsPaused(), p.Interval())
+}
+
+// DetectCluster makes the poller query the gateway server the resolver finds
+// instead of the configured url. It must be called before Start.
+func (p *GatewayPoller) DetectCluster(resolver *ClusterResolver) {
+	p.resolver = resolver
+}
+
+// UseGrpc makes the poller fetch the stats and metadata through the gRPC API instead of
+// the HTTP API. The servers are the ones of the urls. It must be called before Start.
+func (p *GatewayPoller) UseGrpc(client *GrpcClient) {
+	p.grpc = client
+}
+
+// StreamEvents makes the poller subscribe to the events of the gateway in addition to
+// polling the stats. It must be called before Start.
+func (p *GatewayPoller) StreamEvents() {
+	p.events = make(chan Event, 100)
+}
+
+// FetchMetadata makes the poller export the metadata of the gateway in the identity
+// server and fetch it again when it is older than ttl. It must be called before Start.
+func (p *GatewayPoller) FetchMetadata(identityServer *IdentityServer, ttl time.Duration) {
+	p.identityServer = identityServer
+	p.metadataTtl = ttl
+}
+
+// SetLabels sets the custom labels of all gateways and the custom labels taken from
+// the attributes of the gateway, by label name. It must be called before Start.
+func (p *GatewayPoller) SetLabels(labels map[string]string, attributeLabels map[string]string) {
+	p.staticLabels = labels
+	p.attributeLabels = attributeLabels
+	p.labels = gatewayMetricLabels(p.Config(), p.apiService.getUrl(), p.labelDefaults())
+}
+
+// labelDefaults returns the values of the custom labels the gateway doesn't set itself.
+// Attributes of the gateway take precedence over the labels of all gateways.
+func (p *GatewayPoller) labelDefaults() map[string]string {
+	defaults := maps.Clone(p.staticLabels)
+	if defaults == nil {
+		defaults = make(map[string]string)
+	}
+	for name, attribute := range p.attributeLabels {
+		if value, ok := p.attributes[attribute]; ok {
+			defaults[name] = value
+		}
+	}
+	return defaults
+}
+
+// Config returns the configuration the poller was created or last updated with
+func (p *GatewayPoller) Config() GatewayConfig {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.config
+}
+
+// Interval returns the current poll interval
+func (p *GatewayPoller) Interval() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.interval
+}
+
+// Update changes the interval and configuration of a running poller without
+// restarting it. Settings that change the url, key or series need a new poller.
+func (p *GatewayPoller) Update(config GatewayConfig, interval time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.config = config
+	if interval == p.interval {
+		return
+	}
+	p.interval = interval
+	// Only the latest interval matters, drop one the loop hasn't picked up yet
+	select {
+	case <-p.intervalChanged:
+	default:
+	}
+	p.intervalChanged <- interval
+}
+
+// Stop ends the polling loop and waits until a running poll has finished
+func (p *GatewayPoller) Stop() {
+	close(p.stop)
+	<-p.done
+}
+
+// Pause skips all polls until Resume is called
+func (p *GatewayPoller) Pause() {
+	p.paused.Store(true)
+}
+
+// Resume continues polling after Pause
+func (p *GatewayPoller) Resume() {
+	p.paused.Store(false)
+}
+
+// IsPaused reports whether the poller is paused
+func (p *GatewayPoller) IsPaused() bool {
+	return p.paused.Load()
+}
+
+func (p *GatewayPoller) run(pollNow bool, interval time.Duration) {
+	defer close(p.done)
+
+	// The stream hands its events to this loop, which owns the labels of the gateway
+	if p.events != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.runEventStream(ctx)
+		}()
+		defer wg.Wait()
+		defer cancel()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Poll once right away so a gateway has data before the first tick
+	if pollNow {
+		p.poll()
+	}
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case interval := <-p.intervalChanged:
+			ticker.Reset(interval)
+		case <-ticker.C:
+			if !p.IsPaused() {
+				p.poll()
+			}
+		case event := <-p.events:
+			if !p.IsPaused() {
+				p.handleEvent(event)
+			}
+		}
+	}
+}
+
+// runEventStream keeps the event stream of the gateway open until ctx is done.
+// Dropped streams are opened again with an exponential backoff.
+func (p *GatewayPoller) runEventStream(ctx context.Context) {
+	var backoff time.Duration
+	for {
+		err := streamEvents(ctx, p.apiService.getUrl(), p.gatewayId, p.apiService.getApiToken(), func() {
+			backoff = 0
+			slog.Info("Subscribed to the events of the gateway", "gateway_id", p.gatewayId)
+		}, func(event Event) {
+			select {
+			case p.events <- event:
+			case <-ctx.Done():
+			}
+		})
+		if ctx.Err() != nil {
+			return
+		}
+
+		backoff = nextBackoff(backoff)
+		slog.Warn("The event stream of the gateway ended, reconnecting", "gateway_id", p.gatewayId, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// handleEvent counts an event and updates the connection state right away
+func (p *GatewayPoller) handleEvent(event Event) {
+	labels := maps.Clone(p.labels)
+	labels["name"] = event.Name
+	gatewayEvents.With(labels).Inc()
+	switch event.Name {
+	case "gs.gateway.connect":
+		gatewayConnected.With(p.labels).Set(1)
+	case "gs.gateway.disconnect":
+		gatewayConnected.With(p.labels).Set(0)
+	}
+	slog.Debug("Received gateway event", "gateway_id", p.gatewayId, "name", event.Name, "time", event.Time)
+}
+
+// detectCluster points the poller at the gateway server the gateway is connected to
+func (p *GatewayPoller) detectCluster() {
+	url, err := p.resolver.StatsUrl(p.gatewayId, p.apiService.getApiToken())
+	if err != nil {
+		slog.Warn("Failed to detect the cluster of the gateway", "gateway_id", p.gatewayId, "url", p.apiService.getUrl(), "error", err)
+		return
+	}
+	if url == p.apiService.getUrl() {
+		return
+	}
+
+	// The series of the old cluster are replaced by the ones of the new cluster
+	deleteGatewayMetrics(p.gatewayId)
+	p.apiService.SetUrl(url)
+	p.labels = gatewayMetricLabels(p.Config(), url, p.labelDefaults())
+	monitoredGatewayLabels.set(p.gatewayId, p.labels)
+	p.metadataUpdated = time.Time{}
+	slog.Info("Detected the cluster of the gateway", "gateway_id", p.gatewayId, "cluster", p.labels["cluster"], "url", url)
+}
+
+// updateMetadata fetches the metadata and antennas of the gateway when the cached ones are
+// too old. Failures keep the old series and are retried after the ttl as well, so a key
+// without the right to read the gateway info isn't refused on every poll.
+func (p *GatewayPoller) updateMetadata(config GatewayConfig) {
+	if time.Since(p.metadataUpdated) < p.metadataTtl {
+		return
+	}
+	var metadata GatewayMetadata
+	var err error
+	if p.grpc != nil {
+		metadata, err = p.grpc.GatewayMetadata(p.identityServer.url.String(), p.gatewayId, p.apiService.getApiToken())
+	} else {
+		metadata, err = p.identityServer.GatewayMetadata(p.gatewayId, p.apiService.getApiToken())
+	}
+	if err != nil {
+		p.metadataUpdated = time.Now()
+		slog.Warn("Failed to fetch the gateway metadata, retrying after the metadata ttl", "gateway_id", p.gatewayId, "error", err, "ttl", p.metadataTtl)
+		return
+	}
+	// Changed attributes can change the labels, the series with the old ones are replaced
+	p.attributes = metadata.Attributes
+	if labels := gatewayMetricLabels(config, p.apiService.getUrl(), p.labelDefaults()); !maps.Equal(labels, p.labels) {
+		deleteGatewayMetrics(p.gatewayId)
+		p.labels = labels
+		monitoredGatewayLabels.set(p.gatewayId, p.labels)
+		slog.Info("Updated the labels of the gateway from its attributes", "gateway_id", p.gatewayId, "labels", labels)
+	}
+
+	if config.MetricEnabled(metricGroupMetadata) {
+		setGatewayMetadataMetrics(p.labels, metadata)
+	}
+	if config.MetricEnabled(metricGroupLocation) {
+		setGatewayLocationMetrics(p.labels, metadata.Antennas)
+	}
+	p.antennas = metadata.Antennas
+	antennaLocations.set(p.gatewayId, metadata.Antennas)
+	p.metadataUpdated = time.Now()
+	slog.Debug("Updated gateway metadata", "gateway_id", p.gatewayId, "metadata", metadata)
+}
+
+// statsValue is a decoded field of the stats
+type statsValue interface {
+	Float64() (value float64, ok bool, err error)
+}
+
+// setMessageMetrics sets the number of uplink and downlink messages of the gateway
+func (p *GatewayPoller) setMessageMetrics(stats GatewayStats) {
+	if downlinkMessages, ok := p.parseStatsField("downlink_count", stats.DownlinkCount); ok {
+		numberOfDownlinkMessages.With(p.labels).Set(downlinkMessages)
+	}
+	if uplinkMessages, ok := p.parseStatsField("uplink_count", stats.UplinkCount); ok {
+		numberOfUplinkMessages.With(p.labels).Set(uplinkMessages)
+	}
+}
+
+// setRoundTripTimeMetrics sets the minimal, median and maximal round trip time of the gateway
+// as the quantiles 0, 0.5 and 1. Missing and invalid fields are skipped on their own.
+func (p *GatewayPoller) setRoundTripTimeMetrics(rtt RoundTripTimes) {
+	for _, quantile := range []struct {
+		field, quantile string
+		value           ProtoDuration
+	}{
+		{"round_trip_times.min", "0", rtt.Min},
+		{"round_trip_times.median", "0.5", rtt.Median},
+		{"round_trip_times.max", "1", rtt.Max},
+	} {
+		if seconds, ok := p.parseStatsField(quantile.field, quantile.value); ok {
+			setRoundTripTime(p.labels, quantile.quantile, seconds)
+		}
+	}
+}
+
+// parseStatsField returns the value of a field of the stats. Fields without a value are
+// skipped, fields that couldn't be parsed are logged and counted.
+func (p *GatewayPoller) parseStatsField(field string, value statsValue) (float64, bool) {
+	parsed, ok, err := value.Float64()
+	if err != nil {
+		labels := maps.Clone(p.labels)
+		labels["field"] = field
+		statsParseErrors.With(labels).Inc()
+		slog.Warn("Failed to parse a field of the gateway statistics", "gateway_id", p.gatewayId, "field", field, "error", err)
+		return 0, false
+	}
+	return parsed, ok
+}
+
+// fetchStats gets the stats through the configured API
+func (p *GatewayPoller) fetchStats() (GatewayStats, error) {
+	if p.grpc != nil {
+		return p.grpc.GatewayConnectionStats(p.apiService.getUrl(), p.gatewayId, p.apiService.getApiToken())
+	}
+	return p.apiService.Get()
+}
+
+// poll fetches the stats once and updates the prometheus metrics
+func (p *GatewayPoller) poll() {
+	gatewayId := p.gatewayId
+	config := p.Config()
+	start := time.Now()
+
+	if p.resolver != nil {
+		p.detectCluster()
+	}
+	if p.identityServer != nil && (len(p.attributeLabels) > 0 || config.MetricEnabled(metricGroupMetadata) || config.MetricEnabled(metricGroupLocation)) {
+		p.updateMetadata(config)
+	}
+
+	response, err := p.fetchStats()
+	apiCallsTotal.Inc()
+	slog.Debug("Getting gateway statistics", "gateway_id", gatewayId)
+
+	var statusError *StatusError
+	if errors.As(err, &statusError) && statusError.StatusCode == http.StatusNotFound {
+		apiCallFailures.Inc()
+		gatewayConnected.With(p.labels).Set(0)
+		if p.resolver != nil {
+			// The gateway may have moved to another cluster, look it up again on the next poll
+			p.resolver.Invalidate(gatewayId)
+			slog.Error("The gateway is not connected to the detected cluster", "gateway_id", gatewayId, "error", err)
+		} else {
+			slog.Error("The gateway is not connected to the configured cluster or doesn't exist, check the base url or enable the cluster detection", "gateway_id", gatewayId, "error", err)
+		}
+		return
+	}
+	if err != nil {
+		apiCallFailures.Inc()
+		slog.Error("Request to the TTN failed", "gateway_id", gatewayId, "error", err)
+		return
+	}
+
+	slog.Debug("Received gateway statistics", "gateway_id", gatewayId, "stats", response)
+	gatewayConnected.With(p.labels).Set(1)
+
+	// Set values in prometheus. Every field is exported on its own, so one bad field doesn't hide the others.
+	if config.MetricEnabled(metricGroupMessages) {
+		p.setMessageMetrics(response)
+	}
+
+	// Gateways without downlinks have no round trip times
+	if config.MetricEnabled(metricGroupRoundTripTimes) {
+		p.setRoundTripTimeMetrics(response.RoundTripTimes)
+	}
+
+	// The location is configured in the identity server, without it there is nothing to compare to
+	if p.identityServer != nil && config.MetricEnabled(metricGroupLocation) {
+		setGatewayDriftMetrics(p.labels, p.antennas, response.LastStatus.AntennaLocations)
+	}
+
+	duration := time.Since(start).Seconds()
+	slog.Info("Updated gateway statistics", "gateway_id", gatewayId, "duration_seconds", duration)
+	lastApiCallDuration.Set(duration)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGatewayPoller_ParseStatsField(t *testing.T) {
+	poller := NewGatewayPoller(GatewayConfig{ID: "gw-parse"}, NewTTNApiService("http://localhost/stats", "test-key"), time.Hour)
+	defer deleteGatewayMetrics("gw-parse")
+	fieldLabels := func(field string) prometheus.Labels {
+		labels := maps.Clone(poller.labels)
+		labels["field"] = field
+		return labels
+	}
+
+	t.Run("Valid field", func(t *testing.T) {
+		seconds, ok := poller.parseStatsField("round_trip_times.min", NewProtoDuration(250*time.Millisecond))
+		assert.True(t, ok)
+		assert.Equal(t, 0.25, seconds)
+	})
+
+	t.Run("Missing field is skipped silently", func(t *testing.T) {
+		_, ok := poller.parseStatsField("round_trip_times.max", ProtoDuration{})
+		assert.False(t, ok)
+		assert.Equal(t, 0.0, testutil.ToFloat64(statsParseErrors.With(fieldLabels("round_trip_times.max"))))
+	})
+
+	t.Run("Missing count is zero", func(t *testing.T) {
+		count, ok := poller.parseStatsField("uplink_count", Uint64String{})
+		assert.True(t, ok)
+		assert.Zero(t, count)
+	})
+
+	t.Run("Invalid field is counted", func(t *testing.T) {
+		var stats GatewayStats
+		assert.Nil(t, json.Unmarshal([]byte(`{"uplink_count": "many"}`), &stats))
+		_, ok := poller.parseStatsField("uplink_count", stats.UplinkCount)
+		assert.False(t, ok)
+		_, ok = poller.parseStatsField("uplink_count", stats.UplinkCount)
+		assert.False(t, ok)
+		assert.Equal(t, 2.0, testutil.ToFloat64(statsParseErrors.With(fieldLabels("uplink_count"))))
+	})
+}
+
+func TestGatewayPoller_MessageMetrics(t *testing.T) {
+	poller := NewGatewayPoller(GatewayConfig{ID: "gw-messages"}, NewTTNApiService("http://localhost/stats", "test-key"), time.Hour)
+	defer deleteGatewayMetrics("gw-messages")
+
+	// The round trip times are only sampled from some downlinks
+	var stats GatewayStats
+	assert.Nil(t, json.Unmarshal([]byte(`{"uplink_count": "42", "downlink_count": "12", "round_trip_times": {"count": "5"}}`), &stats))
+	poller.setMessageMetrics(stats)
+	assert.Equal(t, 12.0, testutil.ToFloat64(numberOfDownlinkMessages.With(poller.labels)))
+	assert.Equal(t, 42.0, testutil.ToFloat64(numberOfUplinkMessages.With(poller.labels)))
+}
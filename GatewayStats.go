@@ -1,89 +1,73 @@
 package main
 
 import (
-	"fmt"
-	"strconv"
+	"log/slog"
 	"time"
 )
 
+// GatewayStats are the connection stats of a gateway in the Gateway Server.
+// The versions and advanced status are free form, their keys depend on the gateway.
 type GatewayStats struct {
-	ConnectedAt          time.Time `json:"connected_at"`
-	Protocol             string    `json:"protocol"`
+	ConnectedAt          time.Time `json:"connected_at" schema:"required"`
+	DisconnectedAt       time.Time `json:"disconnected_at"`
+	Protocol             string    `json:"protocol" schema:"required"`
 	LastStatusReceivedAt time.Time `json:"last_status_received_at"`
 	LastStatus           struct {
+		Time     time.Time `json:"time"`
+		BootTime time.Time `json:"boot_time"`
 		Versions struct {
 			Package  string `json:"package"`
 			Platform string `json:"platform"`
 			Station  string `json:"station"`
 			Firmware string `json:"firmware"`
-		} `json:"versions"`
+		} `json:"versions" schema:"open"`
 		Advanced struct {
 			Features string `json:"features"`
 			Model    string `json:"model"`
-		} `json:"advanced"`
+		} `json:"advanced" schema:"open"`
+		AntennaLocations []Location         `json:"antenna_locations"`
+		IP               []string           `json:"ip"`
+		Metrics          map[string]float64 `json:"metrics"`
 	} `json:"last_status"`
-	LastUplinkReceivedAt time.Time      `json:"last_uplink_received_at"`
-	UplinkCount          string         `json:"uplink_count"`
-	RoundTripTimes       RoundTripTimes `json:"round_trip_times"`
-	GatewayRemoteAddress struct {
+	LastUplinkReceivedAt           time.Time      `json:"last_uplink_received_at"`
+	UplinkCount                    Uint64String   `json:"uplink_count,omitzero"`
+	LastDownlinkReceivedAt         time.Time      `json:"last_downlink_received_at"`
+	DownlinkCount                  Uint64String   `json:"downlink_count,omitzero"`
+	LastTxAcknowledgmentReceivedAt time.Time      `json:"last_tx_acknowledgment_received_at"`
+	TxAcknowledgmentCount          Uint64String   `json:"tx_acknowledgment_count,omitzero"`
+	RoundTripTimes                 RoundTripTimes `json:"round_trip_times"`
+	SubBands                       []SubBand      `json:"sub_bands"`
+	GatewayRemoteAddress           struct {
 		IP string `json:"ip"`
 	} `json:"gateway_remote_address"`
 }
 
-type RoundTripTimes struct {
-	Min    string `json:"min"`
-	Max    string `json:"max"`
-	Median string `json:"median"`
-	Count  int    `json:"count"`
-}
-
-// Method to convert RTT values (min, max, median) to seconds
-func (rtt *RoundTripTimes) ConvertToSeconds() (float64, float64, float64, error) {
-	// Convert each time string to seconds and store in the struct
-	var err error
-	min, err := convertDurationToSeconds(rtt.Min)
-	if err != nil {
-		return 0.0, 0.0, 0.0, fmt.Errorf("error parsing min duration: %v", err)
-	}
-	max, err := convertDurationToSeconds(rtt.Max)
-	if err != nil {
-		return 0.0, 0.0, 0.0, fmt.Errorf("error parsing max duration: %v", err)
-	}
-	median, err := convertDurationToSeconds(rtt.Median)
-	if err != nil {
-		return 0.0, 0.0, 0.0, fmt.Errorf("error parsing median duration: %v", err)
-	}
-	return min, median, max, nil
+// SubBand is the duty cycle utilization of a frequency range
+type SubBand struct {
+	MinFrequency             Uint64String `json:"min_frequency,omitzero"`
+	MaxFrequency             Uint64String `json:"max_frequency,omitzero"`
+	DownlinkUtilizationLimit float64      `json:"downlink_utilization_limit"`
+	DownlinkUtilization      float64      `json:"downlink_utilization"`
 }
 
-// Helper function to convert time.Duration strings to seconds as float64
-func convertDurationToSeconds(durationStr string) (float64, error) {
-	duration, err := time.ParseDuration(durationStr)
-	if err != nil {
-		return 0.0, err
-	}
-	// Convert to seconds and return as float64
-	return duration.Seconds(), nil
+// LogValue makes sure the stats can be logged without the remote address of the gateway
+func (GatewayStats GatewayStats) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("protocol", GatewayStats.Protocol),
+		slog.Time("connected_at", GatewayStats.ConnectedAt),
+		slog.Time("last_status_received_at", GatewayStats.LastStatusReceivedAt),
+		slog.Time("last_uplink_received_at", GatewayStats.LastUplinkReceivedAt),
+		slog.String("uplink_count", GatewayStats.UplinkCount.String()),
+		slog.String("downlink_count", GatewayStats.DownlinkCount.String()),
+		slog.String("firmware", GatewayStats.LastStatus.Versions.Firmware),
+		slog.String("model", GatewayStats.LastStatus.Advanced.Model),
+	)
 }
 
-// Helper function to convert string to float64
-func stringsToFloat64(input string) (float64, error) {
-	// Convert the string to float64 using strconv.ParseFloat
-	result, err := strconv.ParseFloat(input, 64)
-	if err != nil {
-		return -1, err // If there's an error, return -1 and the error
-	}
-	return result, nil // Return the parsed float64 value
-}
-
-// Method to convert RTT values (min, max, median) to seconds
-func (GatewayStats *GatewayStats) GetUplinkCount() (float64, error) {
-	// Convert each time string to seconds and store in the struct
-	var err error
-	uplinkCount, err := stringsToFloat64(GatewayStats.UplinkCount)
-	if err != nil {
-		return -1, fmt.Errorf("error parsing uplinkCount: %v", err)
-	}
-
-	return uplinkCount, nil
+// RoundTripTimes of the downlinks, all fields are left out if the gateway had no downlinks
+type RoundTripTimes struct {
+	Min    ProtoDuration `json:"min,omitzero"`
+	Max    ProtoDuration `json:"max,omitzero"`
+	Median ProtoDuration `json:"median,omitzero"`
+	Count  Uint64String  `json:"count,omitzero"`
 }
@@ -1,157 +1,66 @@
 package main
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
-func TestRoundTripTimes_ConvertToSeconds(t *testing.T) {
-	t.Run("With all valid values", func(t *testing.T) {
+func TestGatewayStats_Unmarshal(t *testing.T) {
+	t.Run("Connection stats of The Things Stack", func(t *testing.T) {
+		var gwStats GatewayStats
+		err := json.Unmarshal([]byte(`{
+			"protocol": "udp",
+			"uplink_count": "18446744073709551615",
+			"round_trip_times": {"min": "0.023s", "max": "0.254s", "median": "0.043s", "count": 20}
+		}`), &gwStats)
 
-		rtt := RoundTripTimes{
-			Min:    "0.2342343454s",
-			Median: "0.4356764s",
-			Max:    "0.87654435s",
-			Count:  42,
-		}
-
-		min, median, max, err := rtt.ConvertToSeconds()
-		assert.InDelta(t, min, 0.2342343454, 0.0000001)
-		assert.InDelta(t, median, 0.4356764, 0.0000001)
-		assert.InDelta(t, max, 0.87654435, 0.0000001)
-		assert.Nil(t, err)
-	})
-
-	t.Run("All valid except min", func(t *testing.T) {
-
-		rtt := RoundTripTimes{
-			Min:    "i am a string",
-			Median: "0.4356764s",
-			Max:    "0.87654435s",
-			Count:  42,
-		}
-
-		min, median, max, err := rtt.ConvertToSeconds()
-		assert.Equal(t, min, 0.0)
-		assert.Equal(t, median, 0.0)
-		assert.Equal(t, max, 0.0)
-
-		expected := "error parsing min duration: time: invalid duration \"i am a string\""
-		assert.EqualError(t, err, expected)
-	})
-
-	t.Run("All valid except median", func(t *testing.T) {
-
-		rtt := RoundTripTimes{
-			Min:    "0.23457s",
-			Median: "another string",
-			Max:    "0.87654435s",
-			Count:  42,
-		}
-
-		min, median, max, err := rtt.ConvertToSeconds()
-		assert.Equal(t, min, 0.0)
-		assert.Equal(t, median, 0.0)
-		assert.Equal(t, max, 0.0)
-
-		expected := "error parsing median duration: time: invalid duration \"another string\""
-		assert.EqualError(t, err, expected)
-	})
-
-	t.Run("All valid except max", func(t *testing.T) {
-
-		rtt := RoundTripTimes{
-			Min:    "0.734565234s",
-			Median: "0.4356764s",
-			Max:    "and another one",
-			Count:  42,
-		}
-
-		min, median, max, err := rtt.ConvertToSeconds()
-		assert.Equal(t, min, 0.0)
-		assert.Equal(t, median, 0.0)
-		assert.Equal(t, max, 0.0)
-
-		expected := "error parsing max duration: time: invalid duration \"and another one\""
-		assert.EqualError(t, err, expected)
-	})
-}
-
-func Test_convertDurationToSeconds(t *testing.T) {
-	t.Run("Valid time 1", func(t *testing.T) {
-
-		result, err := convertDurationToSeconds("0.2343455635s")
-		assert.InDelta(t, result, 0.2343455635, 0.0000001)
 		assert.Nil(t, err)
+		assert.Equal(t, NewUint64String(18446744073709551615), gwStats.UplinkCount)
+		assert.Equal(t, NewProtoDuration(23*time.Millisecond), gwStats.RoundTripTimes.Min)
+		assert.Equal(t, NewProtoDuration(43*time.Millisecond), gwStats.RoundTripTimes.Median)
+		assert.Equal(t, NewProtoDuration(254*time.Millisecond), gwStats.RoundTripTimes.Max)
+		assert.Equal(t, NewUint64String(20), gwStats.RoundTripTimes.Count)
 	})
 
-	t.Run("Valid time 2", func(t *testing.T) {
+	t.Run("Zero values are left out", func(t *testing.T) {
+		var gwStats GatewayStats
+		err := json.Unmarshal([]byte(`{"protocol": "udp"}`), &gwStats)
 
-		result, err := convertDurationToSeconds("5.9876543243456456s")
-		assert.InDelta(t, result, 5.9876543243456456, 0.0000001)
 		assert.Nil(t, err)
+		assert.False(t, gwStats.UplinkCount.Valid)
+		assert.False(t, gwStats.RoundTripTimes.Min.Valid)
 	})
 
-	t.Run("Invalid time", func(t *testing.T) {
-
-		result, err := convertDurationToSeconds("fiftyoneseconds")
-		assert.Equal(t, result, 0.0)
-
-		expected := "time: invalid duration \"fiftyoneseconds\""
-		assert.EqualError(t, err, expected)
-	})
-}
-
-func Test_stringsToFloat64(t *testing.T) {
-	t.Run("Valid float 1", func(t *testing.T) {
+	t.Run("Invalid field keeps the others", func(t *testing.T) {
+		var gwStats GatewayStats
+		err := json.Unmarshal([]byte(`{"uplink_count": "many", "round_trip_times": {"min": "0.023s"}}`), &gwStats)
 
-		result, err := stringsToFloat64("012.2334534534545")
-		assert.InDelta(t, result, 12.2334534534545, 0.0000001)
 		assert.Nil(t, err)
-
+		assert.EqualError(t, gwStats.UplinkCount.Err, `invalid uint64 "many": strconv.ParseUint: parsing "many": invalid syntax`)
+		assert.Equal(t, NewProtoDuration(23*time.Millisecond), gwStats.RoundTripTimes.Min)
 	})
 
-	t.Run("Valid float 2", func(t *testing.T) {
-
-		result, err := stringsToFloat64("2345345.324353")
-		assert.InDelta(t, result, 2345345.324353, 0.001)
+	t.Run("Round trip", func(t *testing.T) {
+		gwStats := GatewayStats{UplinkCount: NewUint64String(42), RoundTripTimes: RoundTripTimes{Median: NewProtoDuration(43 * time.Millisecond)}}
+		data, err := json.Marshal(gwStats)
 		assert.Nil(t, err)
+		assert.Contains(t, string(data), `"uplink_count":"42",`)
+		assert.Contains(t, string(data), `"round_trip_times":{"median":"0.043s"}`)
 
-	})
-
-	t.Run("Invalid float", func(t *testing.T) {
-
-		result, err := stringsToFloat64("three.onefour")
-		assert.Equal(t, result, -1.0)
-
-		expected := "strconv.ParseFloat: parsing \"three.onefour\": invalid syntax"
-		assert.EqualError(t, err, expected)
+		var decoded GatewayStats
+		assert.Nil(t, json.Unmarshal(data, &decoded))
+		assert.Equal(t, gwStats.UplinkCount, decoded.UplinkCount)
+		assert.Equal(t, gwStats.RoundTripTimes, decoded.RoundTripTimes)
 	})
 }
 
-func TestGatewayStats_GetUplinkCount(t *testing.T) {
-	t.Run("Valid value", func(t *testing.T) {
-
-		gwStats := GatewayStats{
-			UplinkCount: "1337",
-		}
-
-		uplinkCount, err := gwStats.GetUplinkCount()
-		assert.Equal(t, uplinkCount, 1337.0)
-		assert.Nil(t, err)
-	})
-
-	t.Run("Invalid value", func(t *testing.T) {
-
-		gwStats := GatewayStats{
-			UplinkCount: "invalid",
-		}
+func TestGatewayStats_AntennaLocations(t *testing.T) {
+	var gwStats GatewayStats
+	err := json.Unmarshal([]byte(`{"last_status": {"antenna_locations": [{"latitude": 52.52, "longitude": 13.405, "altitude": 35, "accuracy": 5, "source": "SOURCE_GPS"}]}}`), &gwStats)
 
-		uplinkCount, err := gwStats.GetUplinkCount()
-		assert.Equal(t, uplinkCount, -1.0)
-
-		expected := "error parsing uplinkCount: strconv.ParseFloat: parsing \"invalid\": invalid syntax"
-		assert.EqualError(t, err, expected)
-	})
+	assert.Nil(t, err)
+	assert.Equal(t, []Location{{Latitude: 52.52, Longitude: 13.405, Altitude: 35, Accuracy: 5, Source: "SOURCE_GPS"}}, gwStats.LastStatus.AntennaLocations)
 }
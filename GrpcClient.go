@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// APIs of The Things Stack the exporter can use
+const (
+	transportRest = "rest"
+	transportGrpc = "grpc"
+)
+
+// Default ports of the gRPC API of The Things Stack
+const (
+	grpcTlsPort       = "8884"
+	grpcPlaintextPort = "1884"
+)
+
+// GrpcClient fetches the gateway stats and metadata through the gRPC API of The Things Stack.
+// It keeps one connection per server, so all gateways of a cluster share a connection.
+type GrpcClient struct {
+	mu       sync.Mutex
+	address  string
+	insecure bool
+	conns    map[string]*grpc.ClientConn
+}
+
+// NewGrpcClient creates a client. If address is empty, the address is derived from the url
+// of every request: the host with port 8884 for https and 1884 without TLS for http.
+// insecure disables TLS for the given address.
+func NewGrpcClient(address string, insecure bool) *GrpcClient {
+	return &GrpcClient{
+		address:  address,
+		insecure: insecure,
+		conns:    make(map[string]*grpc.ClientConn),
+	}
+}
+
+// GatewayConnectionStats calls Gs.GetGatewayConnectionStats on the gateway server of statsUrl
+func (c *GrpcClient) GatewayConnectionStats(statsUrl string, gatewayId string, apiKey string) (GatewayStats, error) {
+	request := dynamicpb.NewMessage(ttnProto.gatewayIdentifiers)
+	request.Set(ttnProto.gatewayIdentifiers.Fields().ByName("gateway_id"), protoreflect.ValueOfString(gatewayId))
+
+	var stats GatewayStats
+	if err := c.invoke(statsUrl, "/ttn.lorawan.v3.Gs/GetGatewayConnectionStats", apiKey, request, ttnProto.gatewayConnectionStats, &stats); err != nil {
+		return GatewayStats{}, err
+	}
+	return stats, nil
+}
+
+// GatewayMetadata calls GatewayRegistry.Get on the identity server at identityServerUrl
+func (c *GrpcClient) GatewayMetadata(identityServerUrl string, gatewayId string, apiKey string) (GatewayMetadata, error) {
+	ids := dynamicpb.NewMessage(ttnProto.gatewayIdentifiers)
+	ids.Set(ttnProto.gatewayIdentifiers.Fields().ByName("gateway_id"), protoreflect.ValueOfString(gatewayId))
+	mask := &fieldmaskpb.FieldMask{Paths: strings.Split(metadataFieldMask, ",")}
+	request := dynamicpb.NewMessage(ttnProto.getGatewayRequest)
+	request.Set(ttnProto.getGatewayRequest.Fields().ByName("gateway_ids"), protoreflect.ValueOfMessage(ids))
+	request.Set(ttnProto.getGatewayRequest.Fields().ByName("field_mask"), protoreflect.ValueOfMessage(mask.ProtoReflect()))
+
+	var metadata GatewayMetadata
+	if err := c.invoke(identityServerUrl, "/ttn.lorawan.v3.GatewayRegistry/Get", apiKey, request, ttnProto.gateway, &metadata); err != nil {
+		return GatewayMetadata{}, fmt.Errorf("looking up the gateway metadata: %w", err)
+	}
+	return metadata, nil
+}
+
+// Close closes all connections
+func (c *GrpcClient) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for address, conn := range c.conns {
+		conn.Close()
+		delete(c.conns, address)
+	}
+}
+
+// invoke calls method and decodes the response like a response of the HTTP API into v
+func (c *GrpcClient) invoke(rawUrl string, method string, apiKey string, request proto.Message, response protoreflect.MessageDescriptor, v any) error {
+	conn, secure, err := c.conn(rawUrl)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	reply := dynamicpb.NewMessage(response)
+	if err := conn.Invoke(ctx, method, request, reply, grpc.PerRPCCredentials(bearerToken{token: apiKey, secure: secure})); err != nil {
+		return grpcError(err)
+	}
+
+	// The JSON of the HTTP API is the proto3 JSON mapping of the same messages
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(reply)
+	if err != nil {
+		return fmt.Errorf("marshalling response: %w", err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("unmarshalling response: %w", err)
+	}
+	return nil
+}
+
+// conn returns the connection to the server of rawUrl and whether it uses TLS
+func (c *GrpcClient) conn(rawUrl string) (*grpc.ClientConn, bool, error) {
+	address, secure, err := c.target(rawUrl)
+	if err != nil {
+		return nil, false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if conn, ok := c.conns[address]; ok {
+		return conn, secure, nil
+	}
+
+	transport := insecure.NewCredentials()
+	if secure {
+		transport = credentials.NewTLS(nil)
+	}
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(transport))
+	if err != nil {
+		return nil, false, fmt.Errorf("connecting to %s: %w", address, err)
+	}
+	c.conns[address] = conn
+	return conn, secure, nil
+}
+
+// target returns the gRPC address for a url of the HTTP API and whether it uses TLS
+func (c *GrpcClient) target(rawUrl string) (string, bool, error) {
+	if c.address != "" {
+		return c.address, !c.insecure, nil
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Hostname() == "" {
+		return "", false, fmt.Errorf("invalid url %q", rawUrl)
+	}
+	if u.Scheme == "http" {
+		return net.JoinHostPort(u.Hostname(), grpcPlaintextPort), false, nil
+	}
+	return net.JoinHostPort(u.Hostname(), grpcTlsPort), true, nil
+}
+
+// bearerToken sends the API key with every call
+type bearerToken struct {
+	token  string
+	secure bool
+}
+
+func (b bearerToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + b.token}, nil
+}
+
+func (b bearerToken) RequireTransportSecurity() bool {
+	return b.secure
+}
+
+// grpcError maps the status codes the exporter handles to the errors of the HTTP API
+func grpcError(err error) error {
+	switch status.Code(err) {
+	case codes.NotFound:
+		return &StatusError{StatusCode: http.StatusNotFound}
+	case codes.Unauthenticated:
+		return &StatusError{StatusCode: http.StatusUnauthorized}
+	case codes.PermissionDenied:
+		return &StatusError{StatusCode: http.StatusForbidden}
+	}
+	return fmt.Errorf("making gRPC request: %w", err)
+}
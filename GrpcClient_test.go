@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// grpcCall is a request the test server received
+type grpcCall struct {
+	authorization string
+	request       string
+}
+
+// startGrpcServer starts an in-process stand-in for the gRPC API of The Things Stack.
+// The handlers return the proto3 JSON of the response or a gRPC error.
+func startGrpcServer(t *testing.T, stats func(gatewayId string) (string, error), gateway func(gatewayId string, paths []string) (string, error)) (string, chan grpcCall) {
+	calls := make(chan grpcCall, 10)
+	handler := func(input protoreflect.MessageDescriptor, output protoreflect.MessageDescriptor, respond func(request *dynamicpb.Message) (string, error)) grpc.MethodHandler {
+		return func(_ any, ctx context.Context, dec func(any) error, _ grpc.UnaryServerInterceptor) (any, error) {
+			request := dynamicpb.NewMessage(input)
+			if err := dec(request); err != nil {
+				return nil, err
+			}
+			md, _ := metadata.FromIncomingContext(ctx)
+			calls <- grpcCall{authorization: md.Get("authorization")[0], request: protojson.Format(request)}
+
+			body, err := respond(request)
+			if err != nil {
+				return nil, err
+			}
+			response := dynamicpb.NewMessage(output)
+			if err := protojson.Unmarshal([]byte(body), response); err != nil {
+				return nil, err
+			}
+			return response, nil
+		}
+	}
+	gatewayId := func(ids protoreflect.Message) string {
+		return ids.Get(ids.Descriptor().Fields().ByName("gateway_id")).String()
+	}
+
+	server := grpc.NewServer()
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "ttn.lorawan.v3.Gs",
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "GetGatewayConnectionStats",
+			Handler: handler(ttnProto.gatewayIdentifiers, ttnProto.gatewayConnectionStats, func(request *dynamicpb.Message) (string, error) {
+				return stats(gatewayId(request))
+			}),
+		}},
+	}, struct{}{})
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: "ttn.lorawan.v3.GatewayRegistry",
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "Get",
+			Handler: handler(ttnProto.getGatewayRequest, ttnProto.gateway, func(request *dynamicpb.Message) (string, error) {
+				fields := ttnProto.getGatewayRequest.Fields()
+				ids := request.Get(fields.ByName("gateway_ids")).Message()
+				mask := request.Get(fields.ByName("field_mask")).Message()
+				list := mask.Get(mask.Descriptor().Fields().ByName("paths")).List()
+				var paths []string
+				for i := 0; i < list.Len(); i++ {
+					paths = append(paths, list.Get(i).String())
+				}
+				return gateway(gatewayId(ids), paths)
+			}),
+		}},
+	}, struct{}{})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return listener.Addr().String(), calls
+}
+
+func TestGrpcClient_GatewayConnectionStats(t *testing.T) {
+	address, calls := startGrpcServer(t, func(gatewayId string) (string, error) {
+		if gatewayId != "gw-grpc" {
+			return "", status.Error(codes.NotFound, "gateway not connected")
+		}
+		return `{
+			"connected_at": "2025-01-02T03:04:05Z",
+			"protocol": "udp",
+			"last_status": {"antenna_locations": [{"latitude": 52.52, "longitude": 13.405, "altitude": 40, "source": "SOURCE_GPS"}]},
+			"uplink_count": "42",
+			"round_trip_times": {"min": "0.04s", "median": "0.05s", "max": "0.12s", "count": 7}
+		}`, nil
+	}, nil)
+
+	client := NewGrpcClient(address, true)
+	defer client.Close()
+
+	stats, err := client.GatewayConnectionStats("https://eu1.cloud.thethings.network/api/v3/gs/gateways/gw-grpc/connection/stats", "gw-grpc", "test-key")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC), stats.ConnectedAt)
+	assert.Equal(t, "udp", stats.Protocol)
+	assert.Equal(t, NewUint64String(42), stats.UplinkCount)
+	assert.Equal(t, NewUint64String(7), stats.RoundTripTimes.Count)
+	assert.Equal(t, NewProtoDuration(40*time.Millisecond), stats.RoundTripTimes.Min)
+	assert.Equal(t, NewProtoDuration(50*time.Millisecond), stats.RoundTripTimes.Median)
+	assert.Equal(t, NewProtoDuration(120*time.Millisecond), stats.RoundTripTimes.Max)
+	assert.Equal(t, []Location{{Latitude: 52.52, Longitude: 13.405, Altitude: 40, Source: "SOURCE_GPS"}}, stats.LastStatus.AntennaLocations)
+
+	call := <-calls
+	assert.Equal(t, "Bearer test-key", call.authorization)
+	assert.Contains(t, call.request, `"gw-grpc"`)
+
+	// Gateways share the connection
+	_, err = client.GatewayConnectionStats("https://eu1.cloud.thethings.network/api/v3/gs/gateways/gw-other/connection/stats", "gw-other", "test-key")
+	assert.Equal(t, &StatusError{StatusCode: http.StatusNotFound}, err)
+	assert.Len(t, client.conns, 1)
+}
+
+func TestGrpcClient_GatewayMetadata(t *testing.T) {
+	address, calls := startGrpcServer(t, nil, func(gatewayId string, paths []string) (string, error) {
+		assert.Equal(t, "gw-grpc", gatewayId)
+		assert.Equal(t, []string{"name", "description", "version_ids", "frequency_plan_ids", "status_public", "auto_update", "update_channel", "enforce_duty_cycle", "attributes", "antennas"}, paths)
+		return `{
+			"ids": {"gateway_id": "gw-grpc"},
+			"name": "Rooftop",
+			"version_ids": {"brand_id": "the-things-industries"},
+			"frequency_plan_ids": ["EU_863_870"],
+			"status_public": true,
+			"attributes": {"site": "berlin"},
+			"antennas": [{"gain": 3.5, "location": {"latitude": 52.52, "longitude": 13.405, "source": "SOURCE_REGISTRY"}}]
+		}`, nil
+	})
+
+	client := NewGrpcClient(address, true)
+	defer client.Close()
+
+	metadata, err := client.GatewayMetadata("https://eu1.cloud.thethings.network", "gw-grpc", "test-key")
+	assert.Nil(t, err)
+	assert.Equal(t, "Rooftop", metadata.Name)
+	assert.Equal(t, "the-things-industries", metadata.VersionIds.BrandId)
+	assert.Equal(t, []string{"EU_863_870"}, metadata.FrequencyPlanIds)
+	assert.True(t, metadata.StatusPublic)
+	assert.Equal(t, map[string]string{"site": "berlin"}, metadata.Attributes)
+	assert.Equal(t, []GatewayAntenna{{
+		Gain:     3.5,
+		Location: &Location{Latitude: 52.52, Longitude: 13.405, Source: "SOURCE_REGISTRY"},
+	}}, metadata.Antennas)
+	assert.Equal(t, "Bearer test-key", (<-calls).authorization)
+}
+
+func TestGrpcClient_Unauthenticated(t *testing.T) {
+	address, _ := startGrpcServer(t, func(string) (string, error) {
+		return "", status.Error(codes.Unauthenticated, "invalid token")
+	}, nil)
+
+	client := NewGrpcClient(address, true)
+	defer client.Close()
+
+	_, err := client.GatewayConnectionStats("https://eu1.cloud.thethings.network", "gw-grpc", "wrong-key")
+	assert.Equal(t, &StatusError{StatusCode: http.StatusUnauthorized}, err)
+}
+
+func TestGrpcClient_Target(t *testing.T) {
+	tests := []struct {
+		name     string
+		client   *GrpcClient
+		url      string
+		expected string
+		secure   bool
+	}{
+		{"https url", NewGrpcClient("", false), "https://eu1.cloud.thethings.network/api/v3/gs/gateways/gw/connection/stats", "eu1.cloud.thethings.network:8884", true},
+		{"http url", NewGrpcClient("", false), "http://localhost:1885/api/v3", "localhost:1884", false},
+		{"configured address", NewGrpcClient("tts.example.com:443", false), "https://eu1.cloud.thethings.network", "tts.example.com:443", true},
+		{"configured insecure address", NewGrpcClient("localhost:1884", true), "https://eu1.cloud.thethings.network", "localhost:1884", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address, secure, err := tt.client.target(tt.url)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.expected, address)
+			assert.Equal(t, tt.secure, secure)
+		})
+	}
+
+	_, _, err := NewGrpcClient("", false).target("not a url")
+	assert.NotNil(t, err)
+}
@@ -1,15 +1,19 @@
 package main
 
 import (
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"time"
+
+	"github.com/prometheus/exporter-toolkit/web"
 )
 
 // HttpService struct
 type HttpService struct {
-	addr string
-	mux  *http.ServeMux
+	addr          string
+	mux           *http.ServeMux
+	webConfigFile string
 }
 
 // NewHttpService initializes the service with an address
@@ -25,6 +29,13 @@ func NewHttpService(addrs ...string) *HttpService {
 	}
 }
 
+// SetWebConfigFile enables TLS and basic auth using a web config file in the
+// format of the prometheus exporter-toolkit. The file is read again on every
+// new connection, so changed certificates are used without a restart.
+func (s *HttpService) SetWebConfigFile(path string) {
+	s.webConfigFile = path
+}
+
 // RegisterRoute lets you add custom routes/handlers
 func (s *HttpService) RegisterRoute(pattern string, handler http.Handler) {
 	s.mux.Handle(pattern, handler)
@@ -32,9 +43,21 @@ func (s *HttpService) RegisterRoute(pattern string, handler http.Handler) {
 
 // Start launches the HTTP server
 func (s *HttpService) Start() error {
+	if err := web.Validate(s.webConfigFile); err != nil {
+		return fmt.Errorf("invalid web config file: %w", err)
+	}
+
+	server := &http.Server{Handler: s.mux}
+	systemdSocket := false
+	flags := &web.FlagConfig{
+		WebListenAddresses: &[]string{s.addr},
+		WebSystemdSocket:   &systemdSocket,
+		WebConfigFile:      &s.webConfigFile,
+	}
+
 	go func() {
-		if err := http.ListenAndServe(s.addr, s.mux); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("HTTP server error: %v", err)
+		if err := web.ListenAndServe(server, flags, slog.Default()); err != nil && err != http.ErrServerClosed {
+			fatal("HTTP server error", "error", err)
 		}
 	}()
 	// Give it a moment to fail if port is in use
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// ErrUnknownEUI is returned when no gateway is registered with an eui
+var ErrUnknownEUI = errors.New("no gateway is registered with the eui")
+
+// IdentityServer reads gateways from the Identity Server of The Things Stack
+type IdentityServer struct {
+	url *url.URL
+}
+
+// NewIdentityServer creates a client for the Identity Server at rawUrl, e.g. https://eu1.cloud.thethings.network.
+// Urls with an API path like the base url of the gateway server are accepted as well.
+func NewIdentityServer(rawUrl string) (*IdentityServer, error) {
+	u, err := url.Parse(apiRootUrl(rawUrl))
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid identity server url %q", rawUrl)
+	}
+	return &IdentityServer{url: u}, nil
+}
+
+// gatewayIdentifiers are the ids of a gateway as returned by the API
+type gatewayIdentifiers struct {
+	GatewayId string `json:"gateway_id"`
+	EUI       string `json:"eui"`
+}
+
+func (is *IdentityServer) service(apiKey string, fieldMask string, path ...string) *TTNApiService {
+	u := is.url.JoinPath(append([]string{"/api/v3"}, path...)...)
+	if fieldMask != "" {
+		u.RawQuery = url.Values{"field_mask": {fieldMask}}.Encode()
+	}
+	return NewTTNApiService(u.String(), apiKey)
+}
+
+// GatewayServerAddress returns the address of the gateway server the gateway is configured for
+func (is *IdentityServer) GatewayServerAddress(gatewayId string, apiKey string) (string, error) {
+	var gateway struct {
+		GatewayServerAddress string `json:"gateway_server_address"`
+	}
+	if err := is.service(apiKey, "gateway_server_address", "gateways", gatewayId).GetJson(&gateway); err != nil {
+		return "", fmt.Errorf("looking up the gateway server address: %w", err)
+	}
+	return gateway.GatewayServerAddress, nil
+}
+
+// GatewayEUI returns the EUI of a gateway, empty if it has none
+func (is *IdentityServer) GatewayEUI(gatewayId string, apiKey string) (string, error) {
+	var gateway struct {
+		Ids gatewayIdentifiers `json:"ids"`
+	}
+	if err := is.service(apiKey, "", "gateways", gatewayId).GetJson(&gateway); err != nil {
+		return "", fmt.Errorf("looking up the gateway eui: %w", err)
+	}
+	return normalizeEUI(gateway.Ids.EUI), nil
+}
+
+// GatewayIdForEUI returns the id of the gateway registered with the EUI
+func (is *IdentityServer) GatewayIdForEUI(eui string, apiKey string) (string, error) {
+	var ids gatewayIdentifiers
+	err := is.service(apiKey, "", "gateways", "identifiers_for_eui").PostJson(map[string]string{"eui": eui}, &ids)
+	var statusError *StatusError
+	if errors.As(err, &statusError) && statusError.StatusCode == http.StatusNotFound {
+		return "", ErrUnknownEUI
+	}
+	if err != nil {
+		return "", fmt.Errorf("looking up the gateway id: %w", err)
+	}
+	if ids.GatewayId == "" {
+		return "", ErrUnknownEUI
+	}
+	return ids.GatewayId, nil
+}
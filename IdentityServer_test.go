@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newMockGatewayRegistry(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer test-key", r.Header.Get("Authorization"))
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v3/gateways/identifiers_for_eui":
+			var req struct {
+				EUI string `json:"eui"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.EUI != "58A0CBFFFE800001" {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"gateway_id": "gw-by-eui", "eui": req.EUI})
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v3/gateways/gw-by-id":
+			json.NewEncoder(w).Encode(map[string]any{"ids": map[string]string{"gateway_id": "gw-by-id", "eui": "58a0cbfffe800002"}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestIdentityServer(t *testing.T) {
+	server := newMockGatewayRegistry(t)
+	identityServer, err := NewIdentityServer(server.URL + "/api/v3/gs/gateways/")
+	assert.Nil(t, err)
+
+	t.Run("Gateway id for eui", func(t *testing.T) {
+		id, err := identityServer.GatewayIdForEUI("58A0CBFFFE800001", "test-key")
+		assert.Nil(t, err)
+		assert.Equal(t, "gw-by-eui", id)
+
+		_, err = identityServer.GatewayIdForEUI("58A0CBFFFE800009", "test-key")
+		assert.ErrorIs(t, err, ErrUnknownEUI)
+	})
+
+	t.Run("Gateway eui", func(t *testing.T) {
+		eui, err := identityServer.GatewayEUI("gw-by-id", "test-key")
+		assert.Nil(t, err)
+		assert.Equal(t, "58A0CBFFFE800002", eui)
+	})
+
+	t.Run("Invalid url", func(t *testing.T) {
+		_, err := NewIdentityServer("eu1")
+		assert.EqualError(t, err, `invalid identity server url "eu1"`)
+	})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Mean radius of the earth in meters
+const earthRadius = 6371008.8
+
+// Location is a position in the format of The Things Stack, the altitude and accuracy are in meters
+type Location struct {
+	Latitude  float64 `json:"latitude" yaml:"latitude"`
+	Longitude float64 `json:"longitude" yaml:"longitude"`
+	Altitude  float64 `json:"altitude" yaml:"altitude,omitempty"`
+	Accuracy  float64 `json:"accuracy" yaml:"accuracy,omitempty"`
+	Source    string  `json:"source" yaml:"source,omitempty"`
+}
+
+// DistanceTo returns the great circle distance to another location in meters, the altitude is ignored
+func (l Location) DistanceTo(other Location) float64 {
+	lat1, lat2 := l.Latitude*math.Pi/180, other.Latitude*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (other.Longitude - l.Longitude) * math.Pi / 180
+
+	// Haversine formula
+	a := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1)*math.Cos(lat2)*math.Pow(math.Sin(dLon/2), 2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// hasNoFix reports whether the location is the 0,0 of a gateway without a GPS fix
+func (l Location) hasNoFix() bool {
+	return l.Latitude == 0 && l.Longitude == 0
+}
+
+// setGatewayDriftMetrics replaces the drift series of a gateway with the distance between the
+// reported and the configured location of each antenna. Antennas without both locations are left out,
+// as are reported locations at 0,0 which gateways send without a GPS fix.
+func setGatewayDriftMetrics(gatewayLabels prometheus.Labels, configured []GatewayAntenna, reported []Location) {
+	gatewayLocationDrift.DeletePartialMatch(prometheus.Labels{"gateway_id": gatewayLabels["gateway_id"]})
+
+	for i, location := range reported {
+		if i >= len(configured) {
+			break
+		}
+		if configured[i].Location == nil || location.hasNoFix() {
+			continue
+		}
+		labels := prometheus.Labels{"antenna": strconv.Itoa(i)}
+		for name, value := range gatewayLabels {
+			labels[name] = value
+		}
+		gatewayLocationDrift.With(labels).Set(location.DistanceTo(*configured[i].Location))
+	}
+}
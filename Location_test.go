@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocation_DistanceTo(t *testing.T) {
+	berlin := Location{Latitude: 52.5200, Longitude: 13.4050}
+	munich := Location{Latitude: 48.1374, Longitude: 11.5755}
+
+	assert.Zero(t, berlin.DistanceTo(berlin))
+	assert.InDelta(t, 504_000, berlin.DistanceTo(munich), 1_000)
+	assert.Equal(t, berlin.DistanceTo(munich), munich.DistanceTo(berlin))
+	// One thousandth of a degree of latitude is about 111 meters
+	assert.InDelta(t, 111.2, berlin.DistanceTo(Location{Latitude: 52.5210, Longitude: 13.4050}), 0.1)
+}
+
+func TestSetGatewayDriftMetrics(t *testing.T) {
+	labels := prometheus.Labels{"gateway_id": "gw-drift", "gateway_eui": "", "cluster": "eu1", "tenant": ""}
+	defer deleteGatewayMetrics("gw-drift")
+	configured := []GatewayAntenna{{Location: &Location{Latitude: 52.5200, Longitude: 13.4050}}, {}}
+
+	setGatewayDriftMetrics(labels, configured, []Location{{Latitude: 52.5210, Longitude: 13.4050, Source: "SOURCE_GPS"}, {}})
+	assert.Equal(t, 1, testutil.CollectAndCount(gatewayLocationDrift))
+	assert.InDelta(t, 111.2, testutil.ToFloat64(gatewayLocationDrift.With(prometheus.Labels{
+		"gateway_id": "gw-drift", "gateway_eui": "", "cluster": "eu1", "tenant": "", "antenna": "0",
+	})), 0.1)
+
+	// The series is removed when the gateway stops reporting its location
+	setGatewayDriftMetrics(labels, configured, nil)
+	assert.Equal(t, 0, testutil.CollectAndCount(gatewayLocationDrift))
+
+	// Antennas without a configured location don't hide the ones after them
+	configured = []GatewayAntenna{{}, {Location: &Location{Latitude: 52.5200, Longitude: 13.4050}}}
+	setGatewayDriftMetrics(labels, configured, []Location{{Latitude: 52.5210, Longitude: 13.4050}, {Latitude: 52.5210, Longitude: 13.4050}})
+	assert.Equal(t, 1, testutil.CollectAndCount(gatewayLocationDrift))
+	assert.InDelta(t, 111.2, testutil.ToFloat64(gatewayLocationDrift.With(prometheus.Labels{
+		"gateway_id": "gw-drift", "gateway_eui": "", "cluster": "eu1", "tenant": "", "antenna": "1",
+	})), 0.1)
+
+	// A gateway without a GPS fix reports 0,0
+	setGatewayDriftMetrics(labels, configured, []Location{{}, {Source: "SOURCE_GPS"}})
+	assert.Equal(t, 0, testutil.CollectAndCount(gatewayLocationDrift))
+}
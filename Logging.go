@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	// TTN API keys look like NNSXS.<id>.<secret>
+	apiKeyPattern = regexp.MustCompile(`NNSXS\.[A-Z0-9]+\.[A-Z0-9]+`)
+	// Candidates for IP addresses, every match is verified with net.ParseIP
+	ipPattern = regexp.MustCompile(`[0-9a-fA-F]*:[0-9a-fA-F:.]*:[0-9a-fA-F:.]*|\d{1,3}(?:\.\d{1,3}){3}`)
+
+	// Attributes whose values are always redacted
+	sensitiveKeys = map[string]bool{
+		"api_key":       true,
+		"apikey":        true,
+		"token":         true,
+		"authorization": true,
+		"password":      true,
+		"ip":            true,
+		"remote_addr":   true,
+	}
+)
+
+// NewLogger creates a structured logger that redacts API keys and IP addresses
+func NewLogger(w io.Writer, level string, format string) (*slog.Logger, error) {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+
+	options := &slog.HandlerOptions{
+		Level:       logLevel,
+		ReplaceAttr: redactAttr,
+	}
+
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q, must be text or json", format)
+	}
+}
+
+// redactAttr removes secrets and personal data from a log attribute
+func redactAttr(groups []string, attr slog.Attr) slog.Attr {
+	if len(groups) == 0 && (attr.Key == slog.TimeKey || attr.Key == slog.LevelKey) {
+		return attr
+	}
+
+	if sensitiveKeys[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, redacted)
+	}
+
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		return slog.String(attr.Key, redactString(attr.Value.String()))
+	case slog.KindAny:
+		switch value := attr.Value.Any().(type) {
+		case error:
+			return slog.String(attr.Key, redactString(value.Error()))
+		case fmt.Stringer:
+			return slog.String(attr.Key, redactString(value.String()))
+		}
+	}
+	return attr
+}
+
+// redactString replaces API keys and IP addresses in free text
+func redactString(value string) string {
+	value = apiKeyPattern.ReplaceAllString(value, redacted)
+	return ipPattern.ReplaceAllStringFunc(value, func(candidate string) string {
+		if net.ParseIP(candidate) != nil {
+			return redacted
+		}
+		return candidate
+	})
+}
+
+// fatal logs an error and exits the application
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLogger(t *testing.T) {
+	t.Run("Valid json logger", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger, err := NewLogger(&buf, "info", "json")
+		assert.Nil(t, err)
+
+		logger.Info("hello", "gateway_id", "my-gateway")
+		logger.Debug("hidden")
+
+		var entry map[string]any
+		assert.Nil(t, json.Unmarshal(buf.Bytes(), &entry))
+		assert.Equal(t, "hello", entry["msg"])
+		assert.Equal(t, "my-gateway", entry["gateway_id"])
+		assert.NotContains(t, buf.String(), "hidden")
+	})
+
+	t.Run("Debug level", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger, err := NewLogger(&buf, "DEBUG", "text")
+		assert.Nil(t, err)
+
+		logger.Debug("visible")
+		assert.Contains(t, buf.String(), "visible")
+	})
+
+	t.Run("Invalid level", func(t *testing.T) {
+		_, err := NewLogger(&bytes.Buffer{}, "verbose", "text")
+		assert.EqualError(t, err, "invalid log level \"verbose\"")
+	})
+
+	t.Run("Invalid format", func(t *testing.T) {
+		_, err := NewLogger(&bytes.Buffer{}, "info", "xml")
+		assert.EqualError(t, err, "invalid log format \"xml\", must be text or json")
+	})
+}
+
+func TestLoggerRedaction(t *testing.T) {
+	var buf bytes.Buffer
+	logger, _ := NewLogger(&buf, "debug", "text")
+
+	t.Run("Sensitive keys", func(t *testing.T) {
+		buf.Reset()
+		logger.Info("test", "api_key", "secret", "IP", "10.0.0.1")
+		assert.Contains(t, buf.String(), "api_key=[REDACTED]")
+		assert.Contains(t, buf.String(), "IP=[REDACTED]")
+		assert.NotContains(t, buf.String(), "secret")
+	})
+
+	t.Run("Values in free text", func(t *testing.T) {
+		buf.Reset()
+		body := `{"gateway_remote_address":{"ip":"192.168.1.20"},"v6":"2001:db8::1","at":"2026-10-19T02:20:18.875Z","key":"NNSXS.ABC123.DEF456"}`
+		logger.Debug("body", "body", body)
+		assert.NotContains(t, buf.String(), "192.168.1.20")
+		assert.NotContains(t, buf.String(), "2001:db8::1")
+		assert.NotContains(t, buf.String(), "NNSXS.ABC123.DEF456")
+		assert.Contains(t, buf.String(), "2026-10-19T02:20:18.875Z")
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		buf.Reset()
+		logger.Error("failed", "error", errors.New("dial tcp 10.1.2.3:443: connection refused"))
+		assert.NotContains(t, buf.String(), "10.1.2.3")
+		assert.Contains(t, buf.String(), "[REDACTED]:443")
+	})
+
+	t.Run("Gateway stats", func(t *testing.T) {
+		buf.Reset()
+		stats := GatewayStats{Protocol: "udp", UplinkCount: NewUint64String(42)}
+		stats.GatewayRemoteAddress.IP = "172.16.0.5"
+		logger.Info("stats", "stats", stats)
+		assert.NotContains(t, buf.String(), "172.16.0.5")
+		assert.Contains(t, buf.String(), "stats.uplink_count=42")
+	})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Tenant of the applications on The Things Network
+const defaultMqttTenant = "ttn"
+
+// Interval of the first retry of a failed subscription, it doubles up to the max reconnect interval
+var mqttSubscribeRetryInterval = 10 * time.Second
+
+// Largest interval between the reconnects and the retries of failed subscriptions
+const mqttMaxRetryInterval = 5 * time.Minute
+
+// MqttApplication is an application whose uplinks are read from the MQTT integration
+type MqttApplication struct {
+	ID     string `yaml:"id"`
+	Tenant string `yaml:"tenant,omitempty"`
+	ApiKey string `yaml:"api_key,omitempty"`
+}
+
+// parseMqttApplication parses an application id with an optional tenant like my-app@ttn
+func parseMqttApplication(s string) MqttApplication {
+	id, tenant, _ := strings.Cut(s, "@")
+	return MqttApplication{ID: id, Tenant: tenant}
+}
+
+// username is the MQTT username and the topic prefix of the application, e.g. my-app@ttn
+func (app MqttApplication) username() string {
+	tenant := app.Tenant
+	if tenant == "" {
+		tenant = defaultMqttTenant
+	}
+	return app.ID + "@" + tenant
+}
+
+// mqttUrl returns the configured MQTT server or the one on the host of the base url with TLS
+func mqttUrl(config *Config) string {
+	if config.MqttUrl != "" {
+		return config.MqttUrl
+	}
+	u, err := url.Parse(config.BaseUrl)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	return "mqtts://" + u.Hostname() + ":8883"
+}
+
+// MqttSubscriber exports the radio quality of the gateways from the uplinks of applications
+// in the MQTT integration of The Things Stack. Every application has its own connection.
+type MqttSubscriber struct {
+	url          string
+	applications []MqttApplication
+	clients      []mqtt.Client
+}
+
+// NewMqttSubscriber creates a subscriber for the applications at the server url.
+// Urls with the scheme mqtts or ssl use TLS, tcp and mqtt don't.
+func NewMqttSubscriber(url string, applications []MqttApplication) *MqttSubscriber {
+	return &MqttSubscriber{
+		url:          url,
+		applications: applications,
+	}
+}
+
+// Start connects to the server in the background. Failed and lost connections are retried.
+func (s *MqttSubscriber) Start() {
+	for _, app := range s.applications {
+		client := mqtt.NewClient(s.clientOptions(app))
+		client.Connect()
+		s.clients = append(s.clients, client)
+	}
+}
+
+// Stop closes all connections
+func (s *MqttSubscriber) Stop() {
+	for _, client := range s.clients {
+		client.Disconnect(250)
+	}
+	s.clients = nil
+}
+
+func (s *MqttSubscriber) clientOptions(app MqttApplication) *mqtt.ClientOptions {
+	username := app.username()
+	topic := "v3/" + username + "/devices/+/up"
+
+	options := mqtt.NewClientOptions()
+	options.AddBroker(s.url)
+	options.SetClientID(mqttClientId(app.ID))
+	options.SetUsername(username)
+	options.SetPassword(app.ApiKey)
+	options.SetConnectRetry(true)
+	options.SetConnectRetryInterval(10 * time.Second)
+	options.SetAutoReconnect(true)
+	options.SetMaxReconnectInterval(mqttMaxRetryInterval)
+
+	// The session isn't kept, so the topic is subscribed again on every connect. A failed
+	// subscription is retried as long as the connection is open, a new connection subscribes again.
+	options.SetOnConnectHandler(func(client mqtt.Client) {
+		retryInterval := mqttSubscribeRetryInterval
+		for {
+			err := subscribeMqttUplinks(client, topic, username)
+			if err == nil {
+				break
+			}
+			mqttConnected.WithLabelValues(username).Set(0)
+			slog.Error("Failed to subscribe to the uplinks of the application, retrying", "application", username, "error", err, "retry_in", retryInterval)
+			time.Sleep(retryInterval)
+			if !client.IsConnectionOpen() {
+				return
+			}
+			retryInterval = min(2*retryInterval, mqttMaxRetryInterval)
+		}
+		mqttConnected.WithLabelValues(username).Set(1)
+		slog.Info("Subscribed to the uplinks of the application", "application", username, "url", s.url)
+	})
+	options.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		mqttConnected.WithLabelValues(username).Set(0)
+		slog.Warn("Lost the MQTT connection of the application, reconnecting", "application", username, "error", err)
+	})
+	return options
+}
+
+// subscribeMqttUplinks subscribes to the uplinks of the application and waits for the result
+func subscribeMqttUplinks(client mqtt.Client, topic string, application string) error {
+	token := client.Subscribe(topic, 0, func(_ mqtt.Client, message mqtt.Message) {
+		handleMqttUplink(application, message.Payload())
+	})
+	if token.Wait() && token.Error() != nil {
+		return token.Error()
+	}
+	// The server grants the subscription with a QoS or refuses it with 0x80
+	if subscribe, ok := token.(*mqtt.SubscribeToken); ok && subscribe.Result()[topic] == 0x80 {
+		return fmt.Errorf("the server refused the subscription to %s", topic)
+	}
+	return nil
+}
+
+// handleMqttUplink adds an uplink message of the MQTT integration to the radio metrics
+func handleMqttUplink(application string, payload []byte) {
+	var uplink ApplicationUplink
+	if err := json.Unmarshal(payload, &uplink); err != nil {
+		slog.Warn("Failed to parse an uplink of the MQTT integration", "application", application, "error", err)
+		return
+	}
+	if uplink.UplinkMessage != nil {
+		observeUplink(uplink)
+	}
+}
+
+// mqttClientId returns a unique client id, a second exporter must not disconnect the first one
+func mqttClientId(applicationId string) string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("ttn-gateway-exporter-%s-%s", applicationId, hex.EncodeToString(suffix))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// startMqttBroker starts an embedded MQTT broker that accepts the given users and passwords
+func startMqttBroker(t *testing.T, address string, users map[string]string) (*mochi.Server, string) {
+	ledger := &auth.Ledger{Users: auth.Users{}}
+	for username, password := range users {
+		ledger.Users[username] = auth.UserRule{Username: auth.RString(username), Password: auth.RString(password)}
+	}
+
+	server := mochi.New(&mochi.Options{InlineClient: true})
+	assert.Nil(t, server.AddHook(new(auth.Hook), &auth.Options{Ledger: ledger}))
+	listener := listeners.NewTCP(listeners.Config{ID: "tcp", Address: address})
+	assert.Nil(t, server.AddListener(listener))
+	assert.Nil(t, server.Serve())
+	return server, listener.Address()
+}
+
+func mqttUplink(deviceId string, gatewayId string, rssi float64) []byte {
+	return fmt.Appendf(nil, `{
+		"end_device_ids": {"device_id": %q, "application_ids": {"application_id": "app-1"}},
+		"uplink_message": {"rx_metadata": [{"gateway_ids": {"gateway_id": %q}, "rssi": %v, "snr": 5}]}
+	}`, deviceId, gatewayId, rssi)
+}
+
+func TestMqttSubscriber(t *testing.T) {
+	t.Cleanup(resetUplinkMetrics)
+	broker, address := startMqttBroker(t, "127.0.0.1:0", map[string]string{"app-1@ttn": "app-key"})
+
+	subscriber := NewMqttSubscriber("tcp://"+address, []MqttApplication{
+		{ID: "app-1", ApiKey: "app-key"},
+		{ID: "app-2", Tenant: "acme", ApiKey: "wrong-key"},
+	})
+	subscriber.Start()
+	defer subscriber.Stop()
+
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(mqttConnected.WithLabelValues("app-1@ttn")) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0.0, testutil.ToFloat64(mqttConnected.WithLabelValues("app-2@acme")))
+
+	assert.Nil(t, broker.Publish("v3/app-1@ttn/devices/dev-1/up", mqttUplink("dev-1", "gw-mqtt", -80), false, 0))
+	assert.Nil(t, broker.Publish("v3/app-1@ttn/devices/dev-2/up", mqttUplink("dev-2", "gw-mqtt", -100), false, 0))
+	assert.Nil(t, broker.Publish("v3/app-1@ttn/devices/dev-1/up", mqttUplink("dev-1", "gw-mqtt", -90), false, 0))
+	// Other messages of the application are not subscribed
+	assert.Nil(t, broker.Publish("v3/app-1@ttn/devices/dev-1/join", mqttUplink("dev-1", "gw-mqtt", -90), false, 0))
+
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(uplinksReceived.WithLabelValues("gw-mqtt", "", "", "")) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	heardDevices.update(time.Now())
+	assert.Equal(t, 2.0, testutil.ToFloat64(uplinkDevices.WithLabelValues("gw-mqtt", "", "", "")))
+	assert.Equal(t, 1, testutil.CollectAndCount(uplinkRssi))
+
+	t.Run("Reconnect", func(t *testing.T) {
+		broker.Close()
+		assert.Eventually(t, func() bool {
+			return testutil.ToFloat64(mqttConnected.WithLabelValues("app-1@ttn")) == 0
+		}, 5*time.Second, 10*time.Millisecond)
+
+		// The subscription is made again on the new broker
+		broker, _ := startMqttBroker(t, address, map[string]string{"app-1@ttn": "app-key"})
+		defer broker.Close()
+		assert.Eventually(t, func() bool {
+			return testutil.ToFloat64(mqttConnected.WithLabelValues("app-1@ttn")) == 1
+		}, 10*time.Second, 10*time.Millisecond)
+
+		assert.Nil(t, broker.Publish("v3/app-1@ttn/devices/dev-3/up", mqttUplink("dev-3", "gw-mqtt", -70), false, 0))
+		assert.Eventually(t, func() bool {
+			heardDevices.update(time.Now())
+			return testutil.ToFloat64(uplinkDevices.WithLabelValues("gw-mqtt", "", "", "")) == 3
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestMqttSubscriber_SubscribeRefused(t *testing.T) {
+	t.Cleanup(resetUplinkMetrics)
+	defer func(interval time.Duration) { mqttSubscribeRetryInterval = interval }(mqttSubscribeRetryInterval)
+	mqttSubscribeRetryInterval = 10 * time.Millisecond
+
+	// The user may connect but not read the uplinks
+	ledger := &auth.Ledger{Users: auth.Users{"app-3@ttn": auth.UserRule{
+		Username: "app-3@ttn",
+		Password: "app-key",
+		ACL:      auth.Filters{"v3/#": auth.Deny},
+	}}}
+	broker := mochi.New(&mochi.Options{InlineClient: true})
+	assert.Nil(t, broker.AddHook(new(auth.Hook), &auth.Options{Ledger: ledger}))
+	listener := listeners.NewTCP(listeners.Config{ID: "tcp", Address: "127.0.0.1:0"})
+	assert.Nil(t, broker.AddListener(listener))
+	assert.Nil(t, broker.Serve())
+	defer broker.Close()
+
+	subscriber := NewMqttSubscriber("tcp://"+listener.Address(), []MqttApplication{{ID: "app-3", ApiKey: "app-key"}})
+	subscriber.Start()
+	defer subscriber.Stop()
+
+	// The connection is kept open while the subscription is retried
+	assert.Eventually(t, func() bool {
+		return subscriber.clients[0].IsConnectionOpen()
+	}, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	assert.True(t, subscriber.clients[0].IsConnectionOpen())
+	assert.Equal(t, 0.0, testutil.ToFloat64(mqttConnected.WithLabelValues("app-3@ttn")))
+}
+
+func TestMqttApplication(t *testing.T) {
+	assert.Equal(t, MqttApplication{ID: "app-1"}, parseMqttApplication("app-1"))
+	assert.Equal(t, MqttApplication{ID: "app-1", Tenant: "acme"}, parseMqttApplication("app-1@acme"))
+	assert.Equal(t, "app-1@ttn", parseMqttApplication("app-1").username())
+	assert.Equal(t, "app-1@acme", parseMqttApplication("app-1@acme").username())
+}
+
+func TestMqttUrl(t *testing.T) {
+	assert.Equal(t, "mqtts://eu1.cloud.thethings.network:8883", mqttUrl(DefaultConfig()))
+	assert.Equal(t, "tcp://localhost:1883", mqttUrl(&Config{MqttUrl: "tcp://localhost:1883"}))
+}
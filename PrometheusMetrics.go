@@ -1,6 +1,11 @@
 package main
 
 import (
+	"maps"
+	"runtime"
+	"slices"
+	"sync"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 )
@@ -28,13 +33,141 @@ var (
 		},
 	)
 
-	// Gateway stats
+	apiKeyLastLoaded = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "ttn_api_key_last_loaded_timestamp_seconds",
+			Help: "The unix timestamp when the TTN API key was last loaded",
+		},
+	)
+
+	apiKeyExpiry = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ttn_api_key_expiry_timestamp_seconds",
+			Help: "The unix timestamp when the TTN API key expires, absent if it never expires",
+		},
+		[]string{},
+	)
+
+	apiKeyRights = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ttn_api_key_rights_info",
+			Help: "The rights granted to the TTN API key",
+		},
+		[]string{"right"},
+	)
+
+	buildInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ttn_exporter_build_info",
+			Help: "A metric with a constant '1' value labeled by the version, commit and Go version the exporter was built with",
+		},
+		[]string{"version", "commit", "goversion"},
+	)
+
+	configLastReloadSuccessful = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "config_last_reload_successful",
+			Help: "Whether the last configuration reload attempt was successful",
+		},
+	)
+
+	configLastReloadSuccess = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "config_last_reload_success_timestamp_seconds",
+			Help: "The unix timestamp of the last successful configuration reload",
+		},
+	)
+
+	apiSchemaUnknownFields = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ttn_api_schema_unknown_fields",
+			Help: "1 for every field of the TTN API responses the exporter doesn't know, only set with STRICT_API_SCHEMA",
+		},
+		[]string{"field"},
+	)
+
+	apiSchemaMissingFields = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "ttn_api_schema_missing_fields",
+			Help: "1 for every required field the TTN API responses left out, only set with STRICT_API_SCHEMA",
+		},
+		[]string{"field"},
+	)
+
+	mqttConnected = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mqtt_connected",
+			Help: "1 if the exporter is subscribed to the uplinks of the application in the MQTT integration, 0 if it isn't",
+		},
+		[]string{"application"},
+	)
+)
+
+// Gateway stats
+var (
+	gatewayConnected         *prometheus.GaugeVec
+	numberOfDownlinkMessages *prometheus.GaugeVec
+	numberOfUplinkMessages   *prometheus.GaugeVec
+	roundTripTime            *prometheus.GaugeVec
+	rtt_min                  *prometheus.GaugeVec
+	rtt_median               *prometheus.GaugeVec
+	rtt_max                  *prometheus.GaugeVec
+	gatewayMetadataInfo      *prometheus.GaugeVec
+	gatewayAttributeInfo     *prometheus.GaugeVec
+	gatewayLatitude          *prometheus.GaugeVec
+	gatewayLongitude         *prometheus.GaugeVec
+	gatewayAltitude          *prometheus.GaugeVec
+	gatewayLocationAccuracy  *prometheus.GaugeVec
+	gatewayAntennaGain       *prometheus.GaugeVec
+	gatewayLocationDrift     *prometheus.GaugeVec
+	statsParseErrors         *prometheus.CounterVec
+	gatewayEvents            *prometheus.CounterVec
+	uplinksReceived          *prometheus.CounterVec
+	uplinkDevices            *prometheus.GaugeVec
+	uplinkExclusiveDevices   *prometheus.GaugeVec
+	uplinkRssi               *prometheus.HistogramVec
+	uplinkSnr                *prometheus.HistogramVec
+	uplinkDataRates          *prometheus.CounterVec
+	uplinkFrequencies        *prometheus.CounterVec
+	receptionDistance        *prometheus.HistogramVec
+	receptionMaxDistance     *prometheus.GaugeVec
+
+	// customGatewayLabelNames are added to every gateway metric after the reserved labels
+	customGatewayLabelNames []string
+
+	// gatewayMetricsMu guards replacing the gateway metrics while they are collected
+	gatewayMetricsMu sync.RWMutex
+
+	// legacyMetricNames makes the pollers set the deprecated metrics as well. It is set at startup.
+	legacyMetricNames bool
+)
+
+func init() {
+	initGatewayMetrics(nil)
+}
+
+// initGatewayMetrics creates the gateway metrics with the reserved and the custom labels
+func initGatewayMetrics(customLabelNames []string) {
+	gatewayMetricsMu.Lock()
+	defer gatewayMetricsMu.Unlock()
+
+	customGatewayLabelNames = customLabelNames
+	labelNames := append(slices.Clone(reservedLabelNames), customLabelNames...)
+
+	gatewayConnected = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gw_connected",
+			Help: "1 if the gateway is connected to the Gateway Server, 0 if it isn't",
+		},
+		labelNames,
+	)
+
 	numberOfDownlinkMessages = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "gw_number_of_downlink_messages",
 			Help: "The total number of downlink messages",
 		},
-		[]string{"gateway_id"}, // Add gateway_id as a label
+		labelNames,
 	)
 
 	numberOfUplinkMessages = prometheus.NewGaugeVec(
@@ -42,44 +175,223 @@ var (
 			Name: "gw_number_of_uplink_messages",
 			Help: "The total number of uplink messages",
 		},
-		[]string{"gateway_id"}, // Add gateway_id as a label
+		labelNames,
+	)
+
+	roundTripTime = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gw_round_trip_time_seconds",
+			Help: "The minimal (quantile 0), median (quantile 0.5) and maximal (quantile 1) round trip time of the downlinks",
+		},
+		append(slices.Clone(labelNames), "quantile"),
 	)
 
+	// Deprecated names, only set with LEGACY_METRIC_NAMES
 	rtt_min = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "gw_rtt_min",
-			Help: "The minimal round trip time in ms",
+			Help: "Deprecated, use gw_round_trip_time_seconds{quantile=\"0\"}. The minimal round trip time in seconds",
 		},
-		[]string{"gateway_id"}, // Add gateway_id as a label
+		labelNames,
 	)
 
 	rtt_median = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "gw_rtt_median",
-			Help: "The median round trip time in ms",
+			Help: "Deprecated, use gw_round_trip_time_seconds{quantile=\"0.5\"}. The median round trip time in seconds",
 		},
-		[]string{"gateway_id"}, // Add gateway_id as a label
+		labelNames,
 	)
 
 	rtt_max = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "gw_rtt_may",
-			Help: "The maximal round trip time in ms",
+			Help: "Deprecated, use gw_round_trip_time_seconds{quantile=\"1\"}. The maximal round trip time in seconds",
 		},
-		[]string{"gateway_id"}, // Add gateway_id as a label
+		labelNames,
+	)
+
+	gatewayMetadataInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gw_metadata_info",
+			Help: "The name, versions and settings of the gateway in the Identity Server",
+		},
+		append(slices.Clone(labelNames), metadataLabelNames...),
+	)
+
+	gatewayAttributeInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gw_attribute_info",
+			Help: "The attributes of the gateway in the Identity Server, one series per attribute",
+		},
+		append(slices.Clone(labelNames), "key", "value"),
+	)
+
+	locationLabelNames := append(slices.Clone(labelNames), "antenna", "source")
+
+	gatewayLatitude = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gw_location_latitude_degrees",
+			Help: "The configured latitude of the gateway antenna",
+		},
+		locationLabelNames,
+	)
+
+	gatewayLongitude = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gw_location_longitude_degrees",
+			Help: "The configured longitude of the gateway antenna",
+		},
+		locationLabelNames,
+	)
+
+	gatewayAltitude = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gw_location_altitude_meters",
+			Help: "The configured altitude of the gateway antenna",
+		},
+		locationLabelNames,
 	)
-)
 
-// InitPrometheus returns a custom registry
-func InitPrometheus(enableRuntimeMetrics bool, enableAppMetrics bool) *prometheus.Registry {
+	gatewayLocationAccuracy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gw_location_accuracy_meters",
+			Help: "The accuracy of the configured location of the gateway antenna",
+		},
+		locationLabelNames,
+	)
+
+	gatewayAntennaGain = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gw_antenna_gain_dbi",
+			Help: "The configured gain of the gateway antenna",
+		},
+		append(slices.Clone(labelNames), "antenna"),
+	)
+
+	gatewayLocationDrift = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gw_location_drift_meters",
+			Help: "The distance between the location reported by the gateway and its configured location",
+		},
+		append(slices.Clone(labelNames), "antenna"),
+	)
+
+	statsParseErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gw_stats_parse_errors_total",
+			Help: "Total number of fields of the gateway stats that couldn't be parsed",
+		},
+		append(slices.Clone(labelNames), "field"),
+	)
+
+	gatewayEvents = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gw_events_total",
+			Help: "Total number of events of the gateway received from the Events API, only counted with STREAM_EVENTS",
+		},
+		append(slices.Clone(labelNames), "name"),
+	)
+
+	// Radio quality of the uplinks of application webhooks and the MQTT integration, by the gateways that received them
+	uplinksReceived = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gw_uplinks_received_total",
+			Help: "Total number of uplinks of the applications received by the gateway",
+		},
+		labelNames,
+	)
+
+	uplinkDevices = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gw_uplink_devices",
+			Help: "The number of distinct devices of the applications the gateway received uplinks of within the device reach window",
+		},
+		labelNames,
+	)
+
+	uplinkExclusiveDevices = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gw_uplink_exclusive_devices",
+			Help: "The number of distinct devices of the applications only the gateway received uplinks of within the device reach window",
+		},
+		labelNames,
+	)
+
+	uplinkRssi = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gw_uplink_rssi_dbm",
+			Help:    "The RSSI of the uplinks of the applications received by the gateway",
+			Buckets: prometheus.LinearBuckets(-130, 10, 11),
+		},
+		labelNames,
+	)
+
+	uplinkSnr = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gw_uplink_snr_db",
+			Help:    "The SNR of the uplinks of the applications received by the gateway",
+			Buckets: prometheus.LinearBuckets(-20, 2.5, 17),
+		},
+		labelNames,
+	)
+
+	uplinkDataRates = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gw_uplink_data_rate_total",
+			Help: "Total number of LoRa uplinks of the applications received by the gateway by spreading factor and bandwidth in Hz",
+		},
+		append(slices.Clone(labelNames), "spreading_factor", "bandwidth"),
+	)
+
+	uplinkFrequencies = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "gw_uplink_frequency_total",
+			Help: "Total number of uplinks of the applications received by the gateway by frequency in Hz",
+		},
+		append(slices.Clone(labelNames), "frequency"),
+	)
+
+	// Distance between the devices and the antennas of the gateways that received their uplinks
+	receptionDistance = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "gw_reception_distance_meters",
+			Help:    "The distance between the devices and the antenna of the gateway that received their uplinks in meters",
+			Buckets: []float64{100, 250, 500, 1000, 2000, 5000, 10000, 20000, 50000, 100000},
+		},
+		labelNames,
+	)
+
+	receptionMaxDistance = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "gw_reception_max_distance_meters",
+			Help: "The largest distance between a device and the antenna of the gateway that received its uplink since the start in meters",
+		},
+		labelNames,
+	)
+}
+
+// InitPrometheus returns a custom registry. The gateway metrics get the custom label names in addition to the gateway_id, gateway_eui, cluster and tenant.
+func InitPrometheus(enableRuntimeMetrics bool, enableAppMetrics bool, customLabelNames ...string) *prometheus.Registry {
 	// Create a new custom registry
 	reg := prometheus.NewRegistry()
 
+	buildInfo.WithLabelValues(version, commit, runtime.Version()).Set(1)
+	reg.MustRegister(buildInfo)
+
 	if enableAppMetrics {
 		// Register your app's custom metrics
 		reg.MustRegister(apiCallsTotal)
 		reg.MustRegister(apiCallFailures)
 		reg.MustRegister(lastApiCallDuration)
+		reg.MustRegister(apiKeyLastLoaded)
+		reg.MustRegister(apiKeyExpiry)
+		reg.MustRegister(apiKeyRights)
+		reg.MustRegister(configLastReloadSuccessful)
+		reg.MustRegister(configLastReloadSuccess)
+		reg.MustRegister(apiSchemaUnknownFields)
+		reg.MustRegister(apiSchemaMissingFields)
+		reg.MustRegister(mqttConnected)
 	}
 
 	if enableRuntimeMetrics {
@@ -88,11 +400,174 @@ func InitPrometheus(enableRuntimeMetrics bool, enableAppMetrics bool) *prometheu
 	}
 
 	// Register gateway metrics
-	reg.MustRegister(numberOfDownlinkMessages)
-	reg.MustRegister(numberOfUplinkMessages)
-	reg.MustRegister(rtt_min)
-	reg.MustRegister(rtt_median)
-	reg.MustRegister(rtt_max)
+	initGatewayMetrics(customLabelNames)
+	reg.MustRegister(gatewayMetricsCollector{appMetrics: enableAppMetrics})
 
 	return reg
 }
+
+// gatewayMetricsCollector collects the current gateway metrics. It describes no
+// metrics, so the registry doesn't pin their label names and a config reload
+// can re-create them with other custom labels. The parse errors are app metrics
+// and only collected with them.
+type gatewayMetricsCollector struct {
+	appMetrics bool
+}
+
+func (gatewayMetricsCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c gatewayMetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	gatewayMetricsMu.RLock()
+	defer gatewayMetricsMu.RUnlock()
+
+	for _, vec := range gatewayMetricVecs() {
+		vec.Collect(ch)
+	}
+	gatewayEvents.Collect(ch)
+	for _, vec := range gatewayUplinkVecs() {
+		vec.Collect(ch)
+	}
+	if c.appMetrics {
+		statsParseErrors.Collect(ch)
+	}
+}
+
+// gatewayMetricVecs returns all metric vectors that carry a gateway_id label
+func gatewayMetricVecs() []*prometheus.GaugeVec {
+	return []*prometheus.GaugeVec{
+		gatewayConnected,
+		numberOfDownlinkMessages,
+		numberOfUplinkMessages,
+		roundTripTime,
+		rtt_min,
+		rtt_median,
+		rtt_max,
+		gatewayMetadataInfo,
+		gatewayAttributeInfo,
+		gatewayLatitude,
+		gatewayLongitude,
+		gatewayAltitude,
+		gatewayLocationAccuracy,
+		gatewayAntennaGain,
+		gatewayLocationDrift,
+	}
+}
+
+// gatewayUplinkVec is a metric of the uplinks received by the gateways
+type gatewayUplinkVec interface {
+	prometheus.Collector
+	DeletePartialMatch(labels prometheus.Labels) int
+}
+
+// gatewayUplinkVecs returns the metrics of the uplinks of the webhooks and MQTT by the gateways that received them
+func gatewayUplinkVecs() []gatewayUplinkVec {
+	return []gatewayUplinkVec{
+		uplinksReceived,
+		uplinkDevices,
+		uplinkExclusiveDevices,
+		uplinkRssi,
+		uplinkSnr,
+		uplinkDataRates,
+		uplinkFrequencies,
+		receptionDistance,
+		receptionMaxDistance,
+	}
+}
+
+// gatewayLocationVecs returns the metrics of the configured antennas
+func gatewayLocationVecs() []*prometheus.GaugeVec {
+	return []*prometheus.GaugeVec{gatewayLatitude, gatewayLongitude, gatewayAltitude, gatewayLocationAccuracy, gatewayAntennaGain}
+}
+
+// deleteGatewayMetrics removes all series of a gateway
+func deleteGatewayMetrics(gatewayId string) {
+	for _, vec := range gatewayMetricVecs() {
+		vec.DeletePartialMatch(prometheus.Labels{"gateway_id": gatewayId})
+	}
+	for _, vec := range gatewayUplinkVecs() {
+		vec.DeletePartialMatch(prometheus.Labels{"gateway_id": gatewayId})
+	}
+	statsParseErrors.DeletePartialMatch(prometheus.Labels{"gateway_id": gatewayId})
+	gatewayEvents.DeletePartialMatch(prometheus.Labels{"gateway_id": gatewayId})
+}
+
+// gatewayLabelRegistry keeps the labels of the monitored gateways, so the metrics of the
+// uplinks of the webhooks and MQTT get the same labels as the ones of the polled stats
+type gatewayLabelRegistry struct {
+	mu        sync.RWMutex
+	byGateway map[string]prometheus.Labels
+}
+
+// set replaces the labels of a monitored gateway
+func (r *gatewayLabelRegistry) set(gatewayId string, labels prometheus.Labels) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.byGateway[gatewayId] = maps.Clone(labels)
+}
+
+// delete forgets the labels of a gateway that is no longer monitored
+func (r *gatewayLabelRegistry) delete(gatewayId string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.byGateway, gatewayId)
+}
+
+// labels returns the gateway labels of the current label names for a gateway. Gateways that
+// aren't monitored, like the others of the network that received an uplink, only have their
+// gateway_id, the other labels are empty. The caller must hold gatewayMetricsMu.
+func (r *gatewayLabelRegistry) labels(gatewayId string) prometheus.Labels {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	known := r.byGateway[gatewayId]
+	labels := prometheus.Labels{}
+	for _, name := range append(slices.Clone(reservedLabelNames), customGatewayLabelNames...) {
+		labels[name] = known[name]
+	}
+	labels["gateway_id"] = gatewayId
+	return labels
+}
+
+// monitoredGatewayLabels are the labels of the running pollers
+var monitoredGatewayLabels = &gatewayLabelRegistry{byGateway: make(map[string]prometheus.Labels)}
+
+// gatewayMetricLabels returns the values of all gateway metric labels for a gateway polled at url.
+// The cluster and tenant are derived from the url unless the gateway sets them.
+// Custom labels the gateway doesn't set are taken from defaults.
+func gatewayMetricLabels(gateway GatewayConfig, url string, defaults map[string]string) prometheus.Labels {
+	cluster, tenant := clusterAndTenant(url)
+	if gateway.Cluster != "" {
+		cluster = gateway.Cluster
+	}
+	if gateway.Tenant != "" {
+		tenant = gateway.Tenant
+	}
+
+	labels := prometheus.Labels{"gateway_id": gateway.ID, "gateway_eui": gateway.EUI, "cluster": cluster, "tenant": tenant}
+	for _, name := range customGatewayLabelNames {
+		value, ok := gateway.Labels[name]
+		if !ok {
+			value = defaults[name]
+		}
+		labels[name] = value
+	}
+	return labels
+}
+
+// setRoundTripTime sets a single quantile of the round trip times of a gateway in seconds
+func setRoundTripTime(labels prometheus.Labels, quantile string, value float64) {
+	quantileLabels := maps.Clone(labels)
+	quantileLabels["quantile"] = quantile
+	roundTripTime.With(quantileLabels).Set(value)
+
+	if !legacyMetricNames {
+		return
+	}
+	switch quantile {
+	case "0":
+		rtt_min.With(labels).Set(value)
+	case "0.5":
+		rtt_median.With(labels).Set(value)
+	case "1":
+		rtt_max.With(labels).Set(value)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetRoundTripTimeMetrics(t *testing.T) {
+	poller := NewGatewayPoller(GatewayConfig{ID: "gw-rtt"}, NewTTNApiService("https://eu1.cloud.thethings.network/api/v3/gs/gateways/", "test-key"), time.Hour)
+	labels := prometheus.Labels{"gateway_id": "gw-rtt", "gateway_eui": "", "cluster": "eu1", "tenant": ""}
+	defer deleteGatewayMetrics("gw-rtt")
+
+	// Distinct values catch swapped min, median and max
+	var rtt RoundTripTimes
+	assert.Nil(t, json.Unmarshal([]byte(`{"min": "0.1s", "median": "0.2s", "max": "0.3s"}`), &rtt))
+
+	t.Run("Quantiles", func(t *testing.T) {
+		poller.setRoundTripTimeMetrics(rtt)
+
+		expected := `
+# HELP gw_round_trip_time_seconds The minimal (quantile 0), median (quantile 0.5) and maximal (quantile 1) round trip time of the downlinks
+# TYPE gw_round_trip_time_seconds gauge
+gw_round_trip_time_seconds{cluster="eu1",gateway_eui="",gateway_id="gw-rtt",quantile="0",tenant=""} 0.1
+gw_round_trip_time_seconds{cluster="eu1",gateway_eui="",gateway_id="gw-rtt",quantile="0.5",tenant=""} 0.2
+gw_round_trip_time_seconds{cluster="eu1",gateway_eui="",gateway_id="gw-rtt",quantile="1",tenant=""} 0.3
+`
+		assert.Nil(t, testutil.CollectAndCompare(roundTripTime, strings.NewReader(expected)))
+		assert.Equal(t, 0, testutil.CollectAndCount(rtt_max))
+	})
+
+	t.Run("Legacy metric names", func(t *testing.T) {
+		legacyMetricNames = true
+		defer func() { legacyMetricNames = false }()
+
+		poller.setRoundTripTimeMetrics(rtt)
+		assert.Equal(t, 0.1, testutil.ToFloat64(rtt_min.With(labels)))
+		assert.Equal(t, 0.2, testutil.ToFloat64(rtt_median.With(labels)))
+		assert.Equal(t, 0.3, testutil.ToFloat64(rtt_max.With(labels)))
+	})
+}
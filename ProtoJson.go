@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// The Things Stack encodes its messages with the proto3 JSON mapping: 64 bit integers are
+// strings, durations are strings like "0.043s" and fields with the zero value are left out.
+// The types below decode these values. A value that can't be parsed doesn't fail the whole
+// message, it is kept in Err so the other fields can still be used.
+
+// Uint64String is an uint64 in the proto3 JSON format, numbers are accepted as well.
+// Valid is false if the field was left out or couldn't be parsed.
+type Uint64String struct {
+	Value uint64
+	Valid bool
+	Err   error
+}
+
+// NewUint64String returns a valid Uint64String
+func NewUint64String(value uint64) Uint64String {
+	return Uint64String{Value: value, Valid: true}
+}
+
+// UnmarshalJSON accepts "42" and 42
+func (u *Uint64String) UnmarshalJSON(data []byte) error {
+	*u = Uint64String{}
+	raw, ok := protoJsonScalar(data)
+	if !ok {
+		return nil
+	}
+	value, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		u.Err = fmt.Errorf("invalid uint64 %s: %w", data, err)
+		return nil
+	}
+	u.Value, u.Valid = value, true
+	return nil
+}
+
+// MarshalJSON encodes the value as a string like The Things Stack does, or null if it isn't valid
+func (u Uint64String) MarshalJSON() ([]byte, error) {
+	if !u.Valid {
+		return []byte("null"), nil
+	}
+	return []byte(strconv.Quote(strconv.FormatUint(u.Value, 10))), nil
+}
+
+// Float64 returns the value for a metric. A missing count is zero in proto3, so only
+// values that couldn't be parsed are not ok.
+func (u Uint64String) Float64() (float64, bool, error) {
+	return float64(u.Value), u.Err == nil, u.Err
+}
+
+// String returns the value in decimal, or an empty string if it isn't valid
+func (u Uint64String) String() string {
+	if !u.Valid {
+		return ""
+	}
+	return strconv.FormatUint(u.Value, 10)
+}
+
+// ProtoDuration is a google.protobuf.Duration in the proto3 JSON format like "0.043s".
+// Other Go durations like "43ms" and numbers of seconds are accepted as well.
+// Valid is false if the field was left out or couldn't be parsed.
+type ProtoDuration struct {
+	Value time.Duration
+	Valid bool
+	Err   error
+}
+
+// NewProtoDuration returns a valid ProtoDuration
+func NewProtoDuration(value time.Duration) ProtoDuration {
+	return ProtoDuration{Value: value, Valid: true}
+}
+
+// UnmarshalJSON accepts "0.043s", "43ms" and 0.043
+func (d *ProtoDuration) UnmarshalJSON(data []byte) error {
+	*d = ProtoDuration{}
+	raw, ok := protoJsonScalar(data)
+	if !ok {
+		return nil
+	}
+
+	var value time.Duration
+	var err error
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		value, err = time.ParseDuration(raw)
+	} else {
+		var seconds float64
+		seconds, err = strconv.ParseFloat(raw, 64)
+		value = time.Duration(seconds * float64(time.Second))
+	}
+	if err != nil {
+		d.Err = fmt.Errorf("invalid duration %s: %w", data, err)
+		return nil
+	}
+	d.Value, d.Valid = value, true
+	return nil
+}
+
+// MarshalJSON encodes the value in seconds like The Things Stack does, or null if it isn't valid
+func (d ProtoDuration) MarshalJSON() ([]byte, error) {
+	if !d.Valid {
+		return []byte("null"), nil
+	}
+	return []byte(strconv.Quote(strconv.FormatFloat(d.Value.Seconds(), 'f', -1, 64) + "s")), nil
+}
+
+// Float64 returns the duration in seconds for a metric. A missing duration has no value, so it isn't ok.
+func (d ProtoDuration) Float64() (float64, bool, error) {
+	return d.Value.Seconds(), d.Valid, d.Err
+}
+
+// protoJsonScalar returns a JSON string without its quotes or a JSON number as it is.
+// It returns false for null.
+func protoJsonScalar(data []byte) (string, bool) {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return "", false
+	}
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		var s string
+		if err := json.Unmarshal(data, &s); err == nil {
+			return s, true
+		}
+	}
+	return string(data), true
+}
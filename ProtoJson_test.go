@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUint64String_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		expected Uint64String
+		err      string
+	}{
+		{name: "String", json: `"1337"`, expected: NewUint64String(1337)},
+		{name: "Number", json: `1337`, expected: NewUint64String(1337)},
+		{name: "Larger than float64 precision", json: `"9007199254740993"`, expected: NewUint64String(9007199254740993)},
+		{name: "Zero", json: `"0"`, expected: NewUint64String(0)},
+		{name: "Null", json: `null`, expected: Uint64String{}},
+		{name: "Invalid", json: `"invalid"`, err: `invalid uint64 "invalid": strconv.ParseUint: parsing "invalid": invalid syntax`},
+		{name: "Negative", json: `-1`, err: `invalid uint64 -1: strconv.ParseUint: parsing "-1": invalid syntax`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value Uint64String
+			assert.Nil(t, json.Unmarshal([]byte(tt.json), &value))
+			if tt.err != "" {
+				assert.False(t, value.Valid)
+				assert.EqualError(t, value.Err, tt.err)
+				return
+			}
+			assert.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func TestProtoDuration_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		json     string
+		expected ProtoDuration
+		err      string
+	}{
+		{name: "Proto duration", json: `"0.043s"`, expected: NewProtoDuration(43 * time.Millisecond)},
+		{name: "Go duration", json: `"43ms"`, expected: NewProtoDuration(43 * time.Millisecond)},
+		{name: "Seconds", json: `0.5`, expected: NewProtoDuration(500 * time.Millisecond)},
+		{name: "Null", json: `null`, expected: ProtoDuration{}},
+		{name: "Invalid", json: `"fiftyoneseconds"`, err: `invalid duration "fiftyoneseconds": time: invalid duration "fiftyoneseconds"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value ProtoDuration
+			assert.Nil(t, json.Unmarshal([]byte(tt.json), &value))
+			if tt.err != "" {
+				assert.False(t, value.Valid)
+				assert.EqualError(t, value.Err, tt.err)
+				return
+			}
+			assert.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func TestProtoJson_Float64(t *testing.T) {
+	// Counts left out by proto3 are zero, durations have no value
+	value, ok, err := Uint64String{}.Float64()
+	assert.Equal(t, 0.0, value)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+
+	_, ok, err = ProtoDuration{}.Float64()
+	assert.False(t, ok)
+	assert.Nil(t, err)
+
+	value, ok, _ = NewProtoDuration(1500 * time.Millisecond).Float64()
+	assert.Equal(t, 1.5, value)
+	assert.True(t, ok)
+}
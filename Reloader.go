@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Reloader applies changes of the config file to the running exporter
+type Reloader struct {
+	mu      sync.Mutex
+	path    string
+	config  *Config
+	manager *GatewayManager
+
+	// OnApiKeyChange is called after a reload changed the global api key
+	OnApiKeyChange func(apiKey string)
+}
+
+// NewReloader creates a reloader for the config file at path. config is the running configuration.
+func NewReloader(path string, config *Config, manager *GatewayManager) *Reloader {
+	return &Reloader{
+		path:    path,
+		config:  config,
+		manager: manager,
+	}
+}
+
+// Reload reads the config file again and applies the difference to the monitored gateways.
+// Settings that are only used at startup are kept and logged.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := r.reload()
+	if err != nil {
+		configLastReloadSuccessful.Set(0)
+		slog.Error("Failed to reload the configuration", "path", r.path, "error", err)
+		return err
+	}
+	configLastReloadSuccessful.Set(1)
+	configLastReloadSuccess.SetToCurrentTime()
+	slog.Info("Reloaded the configuration", "path", r.path)
+	return nil
+}
+
+func (r *Reloader) reload() error {
+	loaded, err := LoadConfig(r.path)
+	if err != nil {
+		return err
+	}
+	config, ignored := reloadableSettings(r.config, loaded)
+	if len(ignored) > 0 {
+		slog.Warn("Changed settings need a restart to take effect", "settings", ignored)
+	}
+
+	apiKey := config.ApiKey
+	if config.ApiKeyFile != "" {
+		if apiKey, err = LoadApiKeyFile(config.ApiKeyFile); err != nil {
+			return err
+		}
+	}
+	oldApiKey := r.manager.ApiKey()
+
+	r.manager.Configure(config.BaseUrl, config.UrlStatsSuffix, apiKey, time.Duration(config.ReadInterval)*time.Second)
+	r.manager.SetLabelNames(config.CustomLabelNames())
+	r.manager.SetLabels(config.Labels, config.AttributeLabels)
+	deviceLocations.set(config.DeviceLocations)
+	err = r.manager.Apply(config.Gateways)
+
+	// Parts of the config may already be applied, so it becomes the running config even on errors
+	r.config = config
+	if apiKey != oldApiKey && r.OnApiKeyChange != nil {
+		r.OnApiKeyChange(apiKey)
+	}
+	if err != nil {
+		return fmt.Errorf("applying gateways: %w", err)
+	}
+	return nil
+}
+
+// ServeHTTP reloads the configuration on a request to /-/reload
+func (r *Reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if err := r.Reload(); err != nil {
+		http.Error(w, "failed to reload the configuration: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// WatchSignal reloads the configuration on every SIGHUP until the context is done
+func (r *Reloader) WatchSignal(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+			slog.Info("Received SIGHUP, reloading the configuration")
+			r.Reload()
+		}
+	}
+}
+
+// WatchFile checks the config file every interval and reloads it when the content changed
+func (r *Reloader) WatchFile(ctx context.Context, interval time.Duration) {
+	last, _ := os.ReadFile(r.path)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			data, err := os.ReadFile(r.path)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				slog.Warn("Failed to read the config file", "path", r.path, "error", err)
+				continue
+			}
+			if bytes.Equal(data, last) {
+				continue
+			}
+			last = data
+			slog.Info("The config file changed, reloading the configuration", "path", r.path)
+			r.Reload()
+		}
+	}
+}
+
+// reloadableSettings returns the running config with the settings that can change at
+// runtime taken from the loaded config, and the names of the changed settings that can't
+func reloadableSettings(running *Config, loaded *Config) (*Config, []string) {
+	config := *running
+	config.ApiKey = loaded.ApiKey
+	config.BaseUrl = loaded.BaseUrl
+	config.UrlStatsSuffix = loaded.UrlStatsSuffix
+	config.ReadInterval = loaded.ReadInterval
+	config.Labels = loaded.Labels
+	config.AttributeLabels = loaded.AttributeLabels
+	config.Gateways = loaded.Gateways
+	config.DeviceLocations = loaded.DeviceLocations
+
+	var ignored []string
+	current, next := reflect.ValueOf(config), reflect.ValueOf(*loaded)
+	for i := 0; i < current.NumField(); i++ {
+		if !reflect.DeepEqual(current.Field(i).Interface(), next.Field(i).Interface()) {
+			name, _, _ := strings.Cut(current.Type().Field(i).Tag.Get("yaml"), ",")
+			ignored = append(ignored, name)
+		}
+	}
+	return &config, ignored
+}
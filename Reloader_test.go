@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloader_Reload(t *testing.T) {
+	server := newMockStatsServer(t)
+	path := writeConfigFile(t, `
+base_url: `+server.URL+`/
+api_key: test-key
+read_interval: 3600
+gateways:
+  - id: gw-keep
+    paused: true
+  - id: gw-removed
+    paused: true
+`)
+	config, err := LoadConfig(path)
+	assert.Nil(t, err)
+
+	reg := InitPrometheus(false, false, config.CustomLabelNames()...)
+	t.Cleanup(func() { initGatewayMetrics(nil) })
+
+	manager := NewGatewayManager(config.BaseUrl, config.UrlStatsSuffix, config.ApiKey, time.Duration(config.ReadInterval)*time.Second)
+	defer manager.StopAll()
+	assert.Nil(t, manager.Apply(config.Gateways))
+
+	reloader := NewReloader(path, config, manager)
+	keep := manager.pollers["gw-keep"]
+
+	// Gateways are kept paused so the tests don't touch the global api call counters
+	t.Run("Apply the difference", func(t *testing.T) {
+		assert.Nil(t, os.WriteFile(path, []byte(`
+base_url: `+server.URL+`/
+api_key: test-key
+read_interval: 3600
+gateways:
+  - id: gw-keep
+    paused: true
+    read_interval: 60
+  - id: gw-new
+    paused: true
+`), 0o600))
+
+		assert.Nil(t, reloader.Reload())
+		assert.Equal(t, []GatewayConfig{
+			{ID: "gw-keep", Paused: true, ReadInterval: 60},
+			{ID: "gw-new", Paused: true},
+		}, manager.List())
+		assert.Same(t, keep, manager.pollers["gw-keep"])
+		assert.Equal(t, time.Minute, keep.Interval())
+		assert.Equal(t, time.Hour, manager.pollers["gw-new"].Interval())
+		assert.Equal(t, 1.0, testutil.ToFloat64(configLastReloadSuccessful))
+	})
+
+	t.Run("Changed labels restart the gateway", func(t *testing.T) {
+		assert.Nil(t, os.WriteFile(path, []byte(`
+base_url: `+server.URL+`/
+api_key: test-key
+gateways:
+  - id: gw-keep
+    paused: true
+    labels:
+      site: roof
+`), 0o600))
+
+		assert.Nil(t, reloader.Reload())
+		assert.Equal(t, []string{"site"}, customGatewayLabelNames)
+		assert.NotSame(t, keep, manager.pollers["gw-keep"])
+		assert.Equal(t, []GatewayConfig{
+			{ID: "gw-keep", Paused: true, Labels: map[string]string{"site": "roof"}},
+		}, manager.List())
+
+		// The registry serves the re-created metrics
+		numberOfUplinkMessages.WithLabelValues("gw-keep", "", "127.0.0.1", "", "roof").Set(1)
+		count, err := testutil.GatherAndCount(reg, "gw_number_of_uplink_messages")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, count)
+
+		// The parse errors get the custom labels as well
+		var stats GatewayStats
+		assert.Nil(t, json.Unmarshal([]byte(`{"uplink_count": "many"}`), &stats))
+		manager.pollers["gw-keep"].parseStatsField("uplink_count", stats.UplinkCount)
+		assert.Equal(t, 1.0, testutil.ToFloat64(statsParseErrors.WithLabelValues("gw-keep", "", "127.0.0.1", "", "roof", "uplink_count")))
+	})
+
+	t.Run("Invalid config keeps the gateways", func(t *testing.T) {
+		assert.Nil(t, os.WriteFile(path, []byte("gateways: ["), 0o600))
+
+		w := httptest.NewRecorder()
+		reloader.ServeHTTP(w, httptest.NewRequest("POST", "/-/reload", nil))
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Len(t, manager.List(), 1)
+		assert.Equal(t, 0.0, testutil.ToFloat64(configLastReloadSuccessful))
+	})
+
+	t.Run("Gateways of the admin API are kept", func(t *testing.T) {
+		_, err := manager.AddAtRuntime(GatewayConfig{ID: "gw-runtime", Paused: true})
+		assert.Nil(t, err)
+
+		// The labels of all gateways change, so the pollers are started again
+		assert.Nil(t, os.WriteFile(path, []byte(`
+base_url: `+server.URL+`/
+api_key: test-key
+labels:
+  site: default
+gateways:
+  - id: gw-keep
+    paused: true
+`), 0o600))
+		assert.Nil(t, reloader.Reload())
+		assert.Equal(t, []GatewayConfig{{ID: "gw-keep", Paused: true}, {ID: "gw-runtime", Paused: true}}, manager.List())
+		assert.Equal(t, "default", manager.pollers["gw-runtime"].labels["site"])
+
+		assert.Nil(t, manager.Remove("gw-runtime"))
+		assert.Nil(t, reloader.Reload())
+		assert.Equal(t, []GatewayConfig{{ID: "gw-keep", Paused: true}}, manager.List())
+	})
+}
+
+func TestReloadableSettings(t *testing.T) {
+	running := DefaultConfig()
+	loaded := DefaultConfig()
+	loaded.Address = ":9100"
+	loaded.LogLevel = "debug"
+	loaded.ReadInterval = 60
+	loaded.Gateways = []GatewayConfig{{ID: "gw"}}
+	loaded.DeviceLocations = map[string]Location{"app/dev": {Latitude: 52.52, Longitude: 13.405}}
+
+	config, ignored := reloadableSettings(running, loaded)
+	assert.Equal(t, []string{"address", "log_level"}, ignored)
+	assert.Equal(t, ":9000", config.Address)
+	assert.Equal(t, "info", config.LogLevel)
+	assert.Equal(t, 60, config.ReadInterval)
+	assert.Equal(t, loaded.Gateways, config.Gateways)
+	assert.Equal(t, loaded.DeviceLocations, config.DeviceLocations)
+}
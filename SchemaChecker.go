@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	jsonUnmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+	timeType            = reflect.TypeFor[time.Time]()
+)
+
+// SchemaChecker compares responses of the TTN API with the fields of GatewayStats to notice
+// changes of the API. Fields tagged with `schema:"required"` are expected in every response,
+// all others may be left out because proto3 leaves out zero values. The keys of objects
+// tagged with `schema:"open"` are not checked.
+type SchemaChecker struct {
+	mu       sync.Mutex
+	expected reflect.Type
+	reported map[string]bool
+}
+
+// NewSchemaChecker creates a checker for the gateway stats
+func NewSchemaChecker() *SchemaChecker {
+	return &SchemaChecker{
+		expected: reflect.TypeFor[GatewayStats](),
+		reported: make(map[string]bool),
+	}
+}
+
+// Check exports and logs the unknown and missing fields of a response, every field is logged once
+func (c *SchemaChecker) Check(data []byte) {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return
+	}
+	unknown, missing := schemaDrift(value, c.expected)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, field := range unknown {
+		apiSchemaUnknownFields.WithLabelValues(field).Set(1)
+		if !c.reported["unknown "+field] {
+			c.reported["unknown "+field] = true
+			slog.Warn("The TTN API returned an unknown field, the exporter may need an update", "field", field)
+		}
+	}
+	for _, field := range missing {
+		apiSchemaMissingFields.WithLabelValues(field).Set(1)
+		if !c.reported["missing "+field] {
+			c.reported["missing "+field] = true
+			slog.Warn("The TTN API left out a required field, it may have been removed", "field", field)
+		}
+	}
+}
+
+// schemaDrift returns the sorted paths of the fields of value that t doesn't have and of the
+// required fields of t that value doesn't have. Elements of lists are joined with "[]".
+func schemaDrift(value any, t reflect.Type) (unknown []string, missing []string) {
+	walkSchema(value, t, "", &unknown, &missing)
+	slices.Sort(unknown)
+	slices.Sort(missing)
+	return slices.Compact(unknown), slices.Compact(missing)
+}
+
+func walkSchema(value any, t reflect.Type, path string, unknown *[]string, missing *[]string) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		list, ok := value.([]any)
+		if !ok {
+			return
+		}
+		for _, element := range list {
+			walkSchema(element, t.Elem(), path+"[]", unknown, missing)
+		}
+
+	case reflect.Struct:
+		// Types with their own decoding are single values
+		if t == timeType || reflect.PointerTo(t).Implements(jsonUnmarshalerType) {
+			return
+		}
+		object, ok := value.(map[string]any)
+		if !ok {
+			return
+		}
+
+		fields := make(map[string]reflect.StructField)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			fields[name] = field
+		}
+
+		for name, child := range object {
+			field, ok := fields[name]
+			if !ok {
+				*unknown = append(*unknown, joinSchemaPath(path, name))
+				continue
+			}
+			if field.Tag.Get("schema") == "open" {
+				continue
+			}
+			walkSchema(child, field.Type, joinSchemaPath(path, name), unknown, missing)
+		}
+		for name, field := range fields {
+			if _, ok := object[name]; !ok && field.Tag.Get("schema") == "required" {
+				*missing = append(*missing, joinSchemaPath(path, name))
+			}
+		}
+	}
+}
+
+func joinSchemaPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaDrift(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		unknown []string
+		missing []string
+	}{
+		{
+			name: "Known fields",
+			json: `{"connected_at": "2024-01-01T00:00:00Z", "protocol": "udp", "uplink_count": "42",
+				"round_trip_times": {"min": "0.01s", "count": 3}, "sub_bands": [{"min_frequency": "863000000"}]}`,
+		},
+		{
+			name: "Free form objects are not checked",
+			json: `{"connected_at": "2024-01-01T00:00:00Z", "protocol": "udp",
+				"last_status": {"versions": {"ttn-lw-gateway-server": "3.30.0"}, "advanced": {"temperature": 42}, "metrics": {"ackr": 1}}}`,
+		},
+		{
+			name: "Unknown fields",
+			json: `{"connected_at": "2024-01-01T00:00:00Z", "protocol": "udp", "uplink_frames": "42",
+				"round_trip_times": {"p99": "0.1s"}, "last_status": {"antenna_locations": [{"latitude": 52.5, "hdop": 1.2}]}}`,
+			unknown: []string{"last_status.antenna_locations[].hdop", "round_trip_times.p99", "uplink_frames"},
+		},
+		{
+			name:    "Missing required fields",
+			json:    `{"uplink_count": "42"}`,
+			missing: []string{"connected_at", "protocol"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value any
+			assert.Nil(t, json.Unmarshal([]byte(tt.json), &value))
+
+			unknown, missing := schemaDrift(value, NewSchemaChecker().expected)
+			assert.Equal(t, tt.unknown, unknown)
+			assert.Equal(t, tt.missing, missing)
+		})
+	}
+}
+
+func TestSchemaChecker_Check(t *testing.T) {
+	defer apiSchemaUnknownFields.Reset()
+	defer apiSchemaMissingFields.Reset()
+	checker := NewSchemaChecker()
+
+	checker.Check([]byte(`{"connected_at": "2024-01-01T00:00:00Z", "protocol": "udp", "uplink_frames": "42"}`))
+	checker.Check([]byte(`{"protocol": "udp", "uplink_frames": "43"}`))
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(apiSchemaUnknownFields.WithLabelValues("uplink_frames")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(apiSchemaMissingFields.WithLabelValues("connected_at")))
+	assert.Equal(t, 1, testutil.CollectAndCount(apiSchemaMissingFields))
+	assert.True(t, checker.reported["unknown uplink_frames"])
+}
@@ -1,18 +1,22 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"sync"
 	"time"
 )
 
 type TTNApiService struct {
 	url      string
+	mu       sync.RWMutex
 	apiToken string
 	client   *http.Client
+	schema   *SchemaChecker
 }
 
 func NewTTNApiService(url string, apiToken string) *TTNApiService {
@@ -23,32 +27,117 @@ func NewTTNApiService(url string, apiToken string) *TTNApiService {
 	}
 }
 
+// SetApiToken replaces the token used for all following requests
+func (ttn *TTNApiService) SetApiToken(apiToken string) {
+	ttn.mu.Lock()
+	defer ttn.mu.Unlock()
+	ttn.apiToken = apiToken
+}
+
+func (ttn *TTNApiService) getApiToken() string {
+	ttn.mu.RLock()
+	defer ttn.mu.RUnlock()
+	return ttn.apiToken
+}
+
+// SetUrl replaces the url used for all following requests
+func (ttn *TTNApiService) SetUrl(url string) {
+	ttn.mu.Lock()
+	defer ttn.mu.Unlock()
+	ttn.url = url
+}
+
+func (ttn *TTNApiService) getUrl() string {
+	ttn.mu.RLock()
+	defer ttn.mu.RUnlock()
+	return ttn.url
+}
+
+// StatusError is returned when the TTN API answers with an unexpected status code
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+}
+
+// CheckSchema makes Get compare the stats with the fields of GatewayStats
+func (ttn *TTNApiService) CheckSchema(checker *SchemaChecker) {
+	ttn.schema = checker
+}
+
 func (ttn *TTNApiService) Get() (GatewayStats, error) {
-	req, err := http.NewRequest("GET", ttn.url, nil)
+	data, err := ttn.request(http.MethodGet, nil)
+	if err != nil {
+		return GatewayStats{}, err
+	}
+	var stats GatewayStats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return GatewayStats{}, fmt.Errorf("unmarshalling response: %w", err)
+	}
+	if ttn.schema != nil {
+		ttn.schema.Check(data)
+	}
+	return stats, nil
+}
+
+// GetJson requests the url of the service and unmarshals the response into v
+func (ttn *TTNApiService) GetJson(v any) error {
+	return ttn.do(http.MethodGet, nil, v)
+}
+
+// PostJson sends body as json to the url of the service and unmarshals the response into v
+func (ttn *TTNApiService) PostJson(body any, v any) error {
+	return ttn.do(http.MethodPost, body, v)
+}
+
+func (ttn *TTNApiService) do(method string, body any, v any) error {
+	data, err := ttn.request(method, body)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("unmarshalling response: %w", err)
+	}
+	return nil
+}
+
+// request sends body as json to the url of the service and returns the body of the response
+func (ttn *TTNApiService) request(method string, body any) ([]byte, error) {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("marshalling request: %w", err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	url := ttn.getUrl()
+	req, err := http.NewRequest(method, url, reqBody)
 	if err != nil {
-		return GatewayStats{}, fmt.Errorf("creating request: %w", err)
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Add("Authorization", "Bearer "+ttn.getApiToken())
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
 	}
-	req.Header.Add("Authorization", "Bearer "+ttn.apiToken)
 
 	resp, err := ttn.client.Do(req)
 	if err != nil {
-		return GatewayStats{}, fmt.Errorf("making HTTP request: %w", err)
+		return nil, fmt.Errorf("making HTTP request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return GatewayStats{}, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, &StatusError{StatusCode: resp.StatusCode}
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return GatewayStats{}, fmt.Errorf("reading response body: %w", err)
+		return nil, fmt.Errorf("reading response body: %w", err)
 	}
-	log.Println(string(body))
-	var stats GatewayStats
-	if err := json.Unmarshal(body, &stats); err != nil {
-		return GatewayStats{}, fmt.Errorf("unmarshalling response: %w", err)
-	}
-
-	return stats, nil
+	slog.Debug("Received TTN response", "url", url, "body", string(respBody))
+	return respBody, nil
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -26,3 +27,19 @@ func TestNewTTNApiService(t *testing.T) {
 	expectedTimeout := 10 * time.Second
 	assert.Equal(t, service.client.Timeout, expectedTimeout, "Timeout is not as expected")
 }
+
+func TestTTNApiService_SetApiToken(t *testing.T) {
+	var receivedAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedAuth = r.Header.Get("Authorization")
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	service := NewTTNApiService(server.URL, "old-token")
+	service.SetApiToken("new-token")
+
+	_, err := service.Get()
+	assert.Nil(t, err)
+	assert.Equal(t, "Bearer new-token", receivedAuth)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	// The well-known types the messages below refer to
+	_ "google.golang.org/protobuf/types/known/durationpb"
+	_ "google.golang.org/protobuf/types/known/fieldmaskpb"
+	_ "google.golang.org/protobuf/types/known/structpb"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// The messages of The Things Stack the gRPC client uses. They are declared here instead of
+// generated from the lorawan-stack repository, which would pull in the whole stack.
+// Only the fields the exporter reads are declared, the others are skipped when decoding.
+// The field numbers must match api/ttnpb/*.proto of The Things Stack.
+var ttnProto = func() (messages struct {
+	file                   protoreflect.FileDescriptor
+	gatewayIdentifiers     protoreflect.MessageDescriptor
+	gatewayConnectionStats protoreflect.MessageDescriptor
+	getGatewayRequest      protoreflect.MessageDescriptor
+	gateway                protoreflect.MessageDescriptor
+}) {
+	const (
+		typeDouble = descriptorpb.FieldDescriptorProto_TYPE_DOUBLE
+		typeFloat  = descriptorpb.FieldDescriptorProto_TYPE_FLOAT
+		typeInt32  = descriptorpb.FieldDescriptorProto_TYPE_INT32
+		typeUint32 = descriptorpb.FieldDescriptorProto_TYPE_UINT32
+		typeUint64 = descriptorpb.FieldDescriptorProto_TYPE_UINT64
+		typeBool   = descriptorpb.FieldDescriptorProto_TYPE_BOOL
+		typeString = descriptorpb.FieldDescriptorProto_TYPE_STRING
+		typeBytes  = descriptorpb.FieldDescriptorProto_TYPE_BYTES
+		typeEnum   = descriptorpb.FieldDescriptorProto_TYPE_ENUM
+		typeMsg    = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+	)
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:   proto.String(name),
+			Number: proto.Int32(number),
+			Type:   typ.Enum(),
+			Label:  descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	repeated := func(f *descriptorpb.FieldDescriptorProto) *descriptorpb.FieldDescriptorProto {
+		f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+		return f
+	}
+	message := func(name string, fields ...*descriptorpb.FieldDescriptorProto) *descriptorpb.DescriptorProto {
+		return &descriptorpb.DescriptorProto{Name: proto.String(name), Field: fields}
+	}
+	// mapField adds the entry message of a map<string, value> field to parent
+	mapField := func(parent *descriptorpb.DescriptorProto, name string, entry string, number int32, value descriptorpb.FieldDescriptorProto_Type) {
+		parent.NestedType = append(parent.NestedType, &descriptorpb.DescriptorProto{
+			Name:    proto.String(entry),
+			Field:   []*descriptorpb.FieldDescriptorProto{field("key", 1, typeString, ""), field("value", 2, value, "")},
+			Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+		})
+		parent.Field = append(parent.Field, repeated(field(name, number, typeMsg, ".ttn.lorawan.v3."+parent.GetName()+"."+entry)))
+	}
+	method := func(name string, input string, output string) *descriptorpb.MethodDescriptorProto {
+		return &descriptorpb.MethodDescriptorProto{Name: proto.String(name), InputType: proto.String(input), OutputType: proto.String(output)}
+	}
+	value := func(name string, number int32) *descriptorpb.EnumValueDescriptorProto {
+		return &descriptorpb.EnumValueDescriptorProto{Name: proto.String(name), Number: proto.Int32(number)}
+	}
+
+	gatewayStatus := message("GatewayStatus",
+		field("time", 1, typeMsg, ".google.protobuf.Timestamp"),
+		field("boot_time", 2, typeMsg, ".google.protobuf.Timestamp"),
+		repeated(field("antenna_locations", 4, typeMsg, ".ttn.lorawan.v3.Location")),
+		repeated(field("ip", 5, typeString, "")),
+		field("advanced", 99, typeMsg, ".google.protobuf.Struct"),
+	)
+	mapField(gatewayStatus, "versions", "VersionsEntry", 3, typeString)
+	mapField(gatewayStatus, "metrics", "MetricsEntry", 6, typeFloat)
+
+	roundTripTimes := message("RoundTripTimes",
+		field("min", 1, typeMsg, ".google.protobuf.Duration"),
+		field("max", 2, typeMsg, ".google.protobuf.Duration"),
+		field("median", 3, typeMsg, ".google.protobuf.Duration"),
+		field("count", 4, typeUint32, ""),
+	)
+	subBand := message("SubBand",
+		field("min_frequency", 1, typeUint64, ""),
+		field("max_frequency", 2, typeUint64, ""),
+		field("downlink_utilization_limit", 3, typeFloat, ""),
+		field("downlink_utilization", 4, typeFloat, ""),
+	)
+	remoteAddress := message("GatewayRemoteAddress", field("ip", 1, typeString, ""))
+	connectionStats := message("GatewayConnectionStats",
+		field("connected_at", 1, typeMsg, ".google.protobuf.Timestamp"),
+		field("protocol", 2, typeString, ""),
+		field("last_status_received_at", 3, typeMsg, ".google.protobuf.Timestamp"),
+		field("last_status", 4, typeMsg, ".ttn.lorawan.v3.GatewayStatus"),
+		field("last_uplink_received_at", 5, typeMsg, ".google.protobuf.Timestamp"),
+		field("uplink_count", 6, typeUint64, ""),
+		field("last_downlink_received_at", 7, typeMsg, ".google.protobuf.Timestamp"),
+		field("downlink_count", 8, typeUint64, ""),
+		field("round_trip_times", 9, typeMsg, ".ttn.lorawan.v3.GatewayConnectionStats.RoundTripTimes"),
+		repeated(field("sub_bands", 10, typeMsg, ".ttn.lorawan.v3.GatewayConnectionStats.SubBand")),
+		field("gateway_remote_address", 11, typeMsg, ".ttn.lorawan.v3.GatewayRemoteAddress"),
+		field("last_tx_acknowledgment_received_at", 13, typeMsg, ".google.protobuf.Timestamp"),
+		field("tx_acknowledgment_count", 14, typeUint64, ""),
+		field("disconnected_at", 15, typeMsg, ".google.protobuf.Timestamp"),
+	)
+	connectionStats.NestedType = []*descriptorpb.DescriptorProto{roundTripTimes, subBand}
+
+	gateway := message("Gateway",
+		field("ids", 1, typeMsg, ".ttn.lorawan.v3.GatewayIdentifiers"),
+		field("name", 4, typeString, ""),
+		field("description", 5, typeString, ""),
+		field("version_ids", 8, typeMsg, ".ttn.lorawan.v3.GatewayVersionIdentifiers"),
+		field("auto_update", 10, typeBool, ""),
+		field("update_channel", 11, typeString, ""),
+		repeated(field("antennas", 13, typeMsg, ".ttn.lorawan.v3.GatewayAntenna")),
+		field("status_public", 14, typeBool, ""),
+		field("enforce_duty_cycle", 17, typeBool, ""),
+		repeated(field("frequency_plan_ids", 20, typeString, "")),
+	)
+	mapField(gateway, "attributes", "AttributesEntry", 6, typeString)
+
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("ttn/lorawan/v3/exporter.proto"),
+		Package: proto.String("ttn.lorawan.v3"),
+		Syntax:  proto.String("proto3"),
+		Dependency: []string{
+			"google/protobuf/duration.proto",
+			"google/protobuf/field_mask.proto",
+			"google/protobuf/struct.proto",
+			"google/protobuf/timestamp.proto",
+		},
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("LocationSource"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				value("SOURCE_UNKNOWN", 0),
+				value("SOURCE_GPS", 1),
+				value("SOURCE_REGISTRY", 3),
+				value("SOURCE_IP_GEOLOCATION", 4),
+				value("SOURCE_WIFI_RSSI_GEOLOCATION", 5),
+				value("SOURCE_BT_RSSI_GEOLOCATION", 6),
+				value("SOURCE_LORA_RSSI_GEOLOCATION", 7),
+				value("SOURCE_LORA_TDOA_GEOLOCATION", 8),
+				value("SOURCE_COMBINED_GEOLOCATION", 9),
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{
+			message("GatewayIdentifiers",
+				field("gateway_id", 1, typeString, ""),
+				field("eui", 2, typeBytes, ""),
+			),
+			message("GatewayVersionIdentifiers",
+				field("brand_id", 1, typeString, ""),
+				field("model_id", 2, typeString, ""),
+				field("hardware_version", 3, typeString, ""),
+				field("firmware_version", 4, typeString, ""),
+				field("runtime_version", 5, typeString, ""),
+			),
+			message("Location",
+				field("latitude", 1, typeDouble, ""),
+				field("longitude", 2, typeDouble, ""),
+				field("altitude", 3, typeInt32, ""),
+				field("accuracy", 4, typeInt32, ""),
+				field("source", 5, typeEnum, ".ttn.lorawan.v3.LocationSource"),
+			),
+			message("GatewayAntenna",
+				field("gain", 1, typeFloat, ""),
+				field("location", 2, typeMsg, ".ttn.lorawan.v3.Location"),
+			),
+			gatewayStatus,
+			remoteAddress,
+			connectionStats,
+			gateway,
+			message("GetGatewayRequest",
+				field("gateway_ids", 1, typeMsg, ".ttn.lorawan.v3.GatewayIdentifiers"),
+				field("field_mask", 2, typeMsg, ".google.protobuf.FieldMask"),
+			),
+		},
+		Service: []*descriptorpb.ServiceDescriptorProto{
+			{
+				Name:   proto.String("Gs"),
+				Method: []*descriptorpb.MethodDescriptorProto{method("GetGatewayConnectionStats", ".ttn.lorawan.v3.GatewayIdentifiers", ".ttn.lorawan.v3.GatewayConnectionStats")},
+			},
+			{
+				Name:   proto.String("GatewayRegistry"),
+				Method: []*descriptorpb.MethodDescriptorProto{method("Get", ".ttn.lorawan.v3.GetGatewayRequest", ".ttn.lorawan.v3.Gateway")},
+			},
+		},
+	}
+
+	descriptor, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(err)
+	}
+	messages.file = descriptor
+	messages.gatewayIdentifiers = descriptor.Messages().ByName("GatewayIdentifiers")
+	messages.gatewayConnectionStats = descriptor.Messages().ByName("GatewayConnectionStats")
+	messages.getGatewayRequest = descriptor.Messages().ByName("GetGatewayRequest")
+	messages.gateway = descriptor.Messages().ByName("Gateway")
+	return messages
+}()
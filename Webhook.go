@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+)
+
+// Header of the shared secret unless another one is configured
+const defaultWebhookSecretHeader = "X-Webhook-Secret"
+
+// WebhookReceiver exports the radio quality of the gateways from the uplinks of application webhooks
+type WebhookReceiver struct {
+	secretHeader string
+	secret       string
+}
+
+// NewWebhookReceiver creates a receiver that only accepts requests with the secret in secretHeader
+func NewWebhookReceiver(secretHeader string, secret string) *WebhookReceiver {
+	if secretHeader == "" {
+		secretHeader = defaultWebhookSecretHeader
+	}
+	return &WebhookReceiver{
+		secretHeader: secretHeader,
+		secret:       secret,
+	}
+}
+
+// Register adds the webhook route to the http service
+func (wr *WebhookReceiver) Register(httpService *HttpService) {
+	httpService.RegisterRoute("POST /webhooks/uplink", wr)
+}
+
+func (wr *WebhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(wr.secretHeader)), []byte(wr.secret)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Other messages of the same webhook have no uplink message
+	var uplink ApplicationUplink
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&uplink); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if uplink.UplinkMessage != nil {
+		observeUplink(uplink)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+const uplinkWebhookBody = `{
+	"end_device_ids": {"device_id": "dev-1", "application_ids": {"application_id": "app-1"}},
+	"uplink_message": {
+		"rx_metadata": [
+			{"gateway_ids": {"gateway_id": "gw-webhook-1", "eui": "58A0CBFFFE800001"}, "rssi": -97, "channel_rssi": -97, "snr": 7.25},
+			{"gateway_ids": {"gateway_id": "gw-webhook-2"}, "channel_rssi": -121, "snr": -12.5}
+		],
+		"settings": {
+			"data_rate": {"lora": {"bandwidth": 125000, "spreading_factor": 9, "coding_rate": "4/5"}},
+			"frequency": "868300000"
+		}
+	}
+}`
+
+func postWebhook(receiver *WebhookReceiver, header string, secret string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/uplink", strings.NewReader(body))
+	if header != "" {
+		req.Header.Set(header, secret)
+	}
+	recorder := httptest.NewRecorder()
+	receiver.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func resetUplinkMetrics() {
+	for _, vec := range []interface{ Reset() }{uplinksReceived, uplinkDevices, uplinkExclusiveDevices, uplinkRssi, uplinkSnr, uplinkDataRates, uplinkFrequencies, receptionDistance, receptionMaxDistance, mqttConnected} {
+		vec.Reset()
+	}
+	heardDevices = newDeviceReach(24 * time.Hour)
+	receptionRanges = &rangeSet{byGateway: make(map[string]float64)}
+}
+
+func TestWebhookReceiver(t *testing.T) {
+	t.Cleanup(resetUplinkMetrics)
+	receiver := NewWebhookReceiver("", "webhook-secret")
+
+	t.Run("Missing secret", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, postWebhook(receiver, "", "", uplinkWebhookBody).Code)
+		assert.Equal(t, http.StatusUnauthorized, postWebhook(receiver, defaultWebhookSecretHeader, "wrong", uplinkWebhookBody).Code)
+		assert.Equal(t, 0, testutil.CollectAndCount(uplinkRssi))
+	})
+
+	t.Run("Invalid body", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, postWebhook(receiver, defaultWebhookSecretHeader, "webhook-secret", "{").Code)
+	})
+
+	t.Run("Other messages are ignored", func(t *testing.T) {
+		body := `{"end_device_ids": {"device_id": "dev-1"}, "join_accept": {"session_key_id": "AYa"}}`
+		assert.Equal(t, http.StatusNoContent, postWebhook(receiver, defaultWebhookSecretHeader, "webhook-secret", body).Code)
+		assert.Equal(t, 0, testutil.CollectAndCount(uplinkRssi))
+	})
+
+	t.Run("Uplink", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, postWebhook(receiver, defaultWebhookSecretHeader, "webhook-secret", uplinkWebhookBody).Code)
+
+		expected := `
+# HELP gw_uplink_rssi_dbm The RSSI of the uplinks of the applications received by the gateway
+# TYPE gw_uplink_rssi_dbm histogram
+gw_uplink_rssi_dbm_bucket{cluster="",gateway_eui="",gateway_id="gw-webhook-1",tenant="",le="-130"} 0
+gw_uplink_rssi_dbm_bucket{cluster="",gateway_eui="",gateway_id="gw-webhook-1",tenant="",le="-120"} 0
+gw_uplink_rssi_dbm_bucket{cluster="",gateway_eui="",gateway_id="gw-webhook-1",tenant="",le="-110"} 0
+gw_uplink_rssi_dbm_bucket{cluster="",gateway_eui="",gateway_id="gw-webhook-1",tenant="",le="-100"} 0
+gw_uplink_rssi_dbm_bucket{cluster="",gateway_eui="",gateway_id="gw-webhook-1",tenant="",le="-90"} 1
+gw_uplink_rssi_dbm_bucket{cluster="",gateway_eui="",gateway_id="gw-webhook-1",tenant="",le="-80"} 1
+gw_uplink_rssi_dbm_bucket{cluster="",gateway_eui="",gateway_id="gw-webhook-1",tenant="",le="-70"} 1
+gw_uplink_rssi_dbm_bucket{cluster="",gateway_eui="",gateway_id="gw-webhook-1",tenant="",le="-60"} 1
+gw_uplink_rssi_dbm_bucket{cluster="",gateway_eui="",gateway_id="gw-webhook-1",tenant="",le="-50"} 1
+gw_uplink_rssi_dbm_bucket{cluster="",gateway_eui="",gateway_id="gw-webhook-1",tenant="",le="-40"} 1
+gw_uplink_rssi_dbm_bucket{cluster="",gateway_eui="",gateway_id="gw-webhook-1",tenant="",le="-30"} 1
+gw_uplink_rssi_dbm_bucket{cluster="",gateway_eui="",gateway_id="gw-webhook-1",tenant="",le="+Inf"} 1
+gw_uplink_rssi_dbm_sum{cluster="",gateway_eui="",gateway_id="gw-webhook-1",tenant=""} -97
+gw_uplink_rssi_dbm_count{cluster="",gateway_eui="",gateway_id="gw-webhook-1",tenant=""} 1
+gw_uplink_rssi_dbm_bucket{cluster="",gateway_eui="",gateway_id="gw-webhook-2",tenant="",le="-130"} 0
+gw_uplink_rssi_dbm_bucket{cluster="",gateway_eui="",gateway_id="gw-webhook-2",tenant="",le="-120"} 1
+gw_uplink_rssi_dbm_bucket{cluster="",gateway_eui="",gateway_id="gw-webhook-2",tenant="",le="-110"} 1
+gw_uplink_rssi_dbm_bucket{cluster="",gateway_eui="",gateway_id="gw-webhook-2",tenant="",le="-100"} 1
+gw_uplink_rssi_dbm_bucket{cluster="",gateway_eui="",gateway_id="gw-webhook-2",tenant="",le="-90"} 1
+gw_uplink_rssi_dbm_bucket{cluster="",gateway_eui="",gateway_id="gw-webhook-2",tenant="",le="-80"} 1
+gw_uplink_rssi_dbm_bucket{cluster="",gateway_eui="",gateway_id="gw-webhook-2",tenant="",le="-70"} 1
+gw_uplink_rssi_dbm_bucket{cluster="",gateway_eui="",gateway_id="gw-webhook-2",tenant="",le="-60"} 1
+gw_uplink_rssi_dbm_bucket{cluster="",gateway_eui="",gateway_id="gw-webhook-2",tenant="",le="-50"} 1
+gw_uplink_rssi_dbm_bucket{cluster="",gateway_eui="",gateway_id="gw-webhook-2",tenant="",le="-40"} 1
+gw_uplink_rssi_dbm_bucket{cluster="",gateway_eui="",gateway_id="gw-webhook-2",tenant="",le="-30"} 1
+gw_uplink_rssi_dbm_bucket{cluster="",gateway_eui="",gateway_id="gw-webhook-2",tenant="",le="+Inf"} 1
+gw_uplink_rssi_dbm_sum{cluster="",gateway_eui="",gateway_id="gw-webhook-2",tenant=""} -121
+gw_uplink_rssi_dbm_count{cluster="",gateway_eui="",gateway_id="gw-webhook-2",tenant=""} 1
+`
+		assert.Nil(t, testutil.CollectAndCompare(uplinkRssi, strings.NewReader(expected)))
+
+		assert.Equal(t, 2, testutil.CollectAndCount(uplinkSnr))
+		assert.Equal(t, 1.0, testutil.ToFloat64(uplinkDataRates.WithLabelValues("gw-webhook-1", "", "", "", "9", "125000")))
+		assert.Equal(t, 1.0, testutil.ToFloat64(uplinkDataRates.WithLabelValues("gw-webhook-2", "", "", "", "9", "125000")))
+		assert.Equal(t, 1.0, testutil.ToFloat64(uplinkFrequencies.WithLabelValues("gw-webhook-1", "", "", "", "868300000")))
+		assert.Equal(t, 1.0, testutil.ToFloat64(uplinksReceived.WithLabelValues("gw-webhook-2", "", "", "")))
+		heardDevices.update(time.Now())
+		assert.Equal(t, 1.0, testutil.ToFloat64(uplinkDevices.WithLabelValues("gw-webhook-2", "", "", "")))
+		assert.Equal(t, 0.0, testutil.ToFloat64(uplinkExclusiveDevices.WithLabelValues("gw-webhook-2", "", "", "")))
+	})
+
+	t.Run("Custom header", func(t *testing.T) {
+		receiver := NewWebhookReceiver("X-Downlink-Apikey", "webhook-secret")
+		assert.Equal(t, http.StatusUnauthorized, postWebhook(receiver, defaultWebhookSecretHeader, "webhook-secret", uplinkWebhookBody).Code)
+		assert.Equal(t, http.StatusNoContent, postWebhook(receiver, "X-Downlink-Apikey", "webhook-secret", uplinkWebhookBody).Code)
+	})
+}
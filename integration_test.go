@@ -1,15 +1,25 @@
 package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
-	"fmt"
+	"encoding/pem"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
@@ -65,6 +75,140 @@ func TestHealthEndpoint(t *testing.T) {
 	}
 }
 
+// writeSelfSignedCert creates a self-signed certificate for localhost with the given serial number
+func writeSelfSignedCert(t *testing.T, certFile string, keyFile string, serial int64) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600)
+}
+
+// freeAddress returns a local address with a currently unused port
+func freeAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// TestHTTPServiceTLSAndBasicAuth tests the web config file support
+func TestHTTPServiceTLSAndBasicAuth(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	writeSelfSignedCert(t, certFile, keyFile, 1)
+
+	webConfigFile := filepath.Join(dir, "web-config.yml")
+	os.WriteFile(webConfigFile, []byte(`tls_server_config:
+  cert_file: server.crt
+  key_file: server.key
+basic_auth_users:
+  dave: $2y$10$2UXri9cIDdgeKjBo4Rlpx.U3ZLDV8X1IxKmsfOvhcM5oXQt/mLmXq
+`), 0o600)
+
+	addr := freeAddress(t)
+	httpService := NewHttpService(addr)
+	httpService.SetWebConfigFile(webConfigFile)
+	httpService.RegisterRoute("/health", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	if err := httpService.Start(); err != nil {
+		t.Fatalf("Failed to start HTTP service: %v", err)
+	}
+
+	get := func(user string, password string) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		}}
+		req, _ := http.NewRequest("GET", "https://"+addr+"/health", nil)
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		return client.Do(req)
+	}
+
+	t.Run("Without credentials", func(t *testing.T) {
+		resp, err := get("", "")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected status 401, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("With wrong password", func(t *testing.T) {
+		resp, err := get("dave", "wrong")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected status 401, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("With valid credentials", func(t *testing.T) {
+		resp, err := get("dave", "dave123")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", resp.StatusCode)
+		}
+		if serial := resp.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 1 {
+			t.Errorf("Expected certificate serial 1, got %d", serial)
+		}
+	})
+
+	t.Run("Certificate is reloaded", func(t *testing.T) {
+		writeSelfSignedCert(t, certFile, keyFile, 2)
+
+		resp, err := get("dave", "dave123")
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if serial := resp.TLS.PeerCertificates[0].SerialNumber.Int64(); serial != 2 {
+			t.Errorf("Expected certificate serial 2, got %d", serial)
+		}
+	})
+}
+
+// TestHTTPServiceInvalidWebConfig tests that an invalid web config is reported on start
+func TestHTTPServiceInvalidWebConfig(t *testing.T) {
+	webConfigFile := filepath.Join(t.TempDir(), "web-config.yml")
+	os.WriteFile(webConfigFile, []byte("tls_server_config:\n  cert_file: missing.crt\n  key_file: missing.key\n"), 0o600)
+
+	httpService := NewHttpService(freeAddress(t))
+	httpService.SetWebConfigFile(webConfigFile)
+	if err := httpService.Start(); err == nil {
+		t.Error("Expected error for invalid web config, got none")
+	}
+}
+
 // TestPrometheusMetricsEndpoint tests that metrics are exposed correctly
 func TestPrometheusMetricsEndpoint(t *testing.T) {
 	// Initialize Prometheus with app metrics enabled
@@ -134,12 +278,12 @@ func TestTTNApiServiceWithMockServer(t *testing.T) {
 			Protocol:             "udp",
 			LastStatusReceivedAt: time.Now(),
 			LastUplinkReceivedAt: time.Now(),
-			UplinkCount:          "42",
+			UplinkCount:          NewUint64String(42),
 			RoundTripTimes: RoundTripTimes{
-				Min:    "10ms",
-				Max:    "100ms",
-				Median: "50ms",
-				Count:  10,
+				Min:    NewProtoDuration(10 * time.Millisecond),
+				Max:    NewProtoDuration(100 * time.Millisecond),
+				Median: NewProtoDuration(50 * time.Millisecond),
+				Count:  NewUint64String(10),
 			},
 		}
 
@@ -158,12 +302,16 @@ func TestTTNApiServiceWithMockServer(t *testing.T) {
 	}
 
 	// Verify response
-	if stats.UplinkCount != "42" {
-		t.Errorf("Expected UplinkCount '42', got '%s'", stats.UplinkCount)
+	if stats.UplinkCount != NewUint64String(42) {
+		t.Errorf("Expected UplinkCount 42, got '%s'", stats.UplinkCount)
+	}
+
+	if stats.RoundTripTimes.Count != NewUint64String(10) {
+		t.Errorf("Expected RTT Count 10, got '%s'", stats.RoundTripTimes.Count)
 	}
 
-	if stats.RoundTripTimes.Count != 10 {
-		t.Errorf("Expected RTT Count 10, got %d", stats.RoundTripTimes.Count)
+	if stats.RoundTripTimes.Median != NewProtoDuration(50*time.Millisecond) {
+		t.Errorf("Expected RTT median 50ms, got %v", stats.RoundTripTimes.Median.Value)
 	}
 
 	if stats.Protocol != "udp" {
@@ -183,12 +331,12 @@ func TestTTNApiServiceErrorHandling(t *testing.T) {
 			name: "successful request",
 			serverResponse: func(w http.ResponseWriter, r *http.Request) {
 				mockStats := GatewayStats{
-					UplinkCount: "100",
+					UplinkCount: NewUint64String(100),
 					RoundTripTimes: RoundTripTimes{
-						Min:    "5ms",
-						Max:    "50ms",
-						Median: "25ms",
-						Count:  5,
+						Min:    NewProtoDuration(5 * time.Millisecond),
+						Max:    NewProtoDuration(50 * time.Millisecond),
+						Median: NewProtoDuration(25 * time.Millisecond),
+						Count:  NewUint64String(5),
 					},
 				}
 				json.NewEncoder(w).Encode(mockStats)
@@ -244,68 +392,57 @@ func TestTTNApiServiceErrorHandling(t *testing.T) {
 	}
 }
 
-// TestGatewayStatsConversions tests the data conversion methods
+// TestGatewayStatsConversions tests decoding the proto3 JSON of the stats
 func TestGatewayStatsConversions(t *testing.T) {
 	t.Run("RTT conversion success", func(t *testing.T) {
-		rtt := RoundTripTimes{
-			Min:    "10ms",
-			Max:    "100ms",
-			Median: "50ms",
-			Count:  5,
-		}
-
-		min, median, max, err := rtt.ConvertToSeconds()
-		if err != nil {
+		var rtt RoundTripTimes
+		if err := json.Unmarshal([]byte(`{"min": "0.010s", "max": "0.100s", "median": "0.050s", "count": 5}`), &rtt); err != nil {
 			t.Fatalf("Expected no error, got: %v", err)
 		}
 
-		if min != 0.01 {
+		if min, _, _ := rtt.Min.Float64(); min != 0.01 {
 			t.Errorf("Expected min 0.01, got %f", min)
 		}
-		if median != 0.05 {
+		if median, _, _ := rtt.Median.Float64(); median != 0.05 {
 			t.Errorf("Expected median 0.05, got %f", median)
 		}
-		if max != 0.1 {
+		if max, _, _ := rtt.Max.Float64(); max != 0.1 {
 			t.Errorf("Expected max 0.1, got %f", max)
 		}
 	})
 
 	t.Run("RTT conversion with invalid data", func(t *testing.T) {
-		rtt := RoundTripTimes{
-			Min:    "invalid",
-			Max:    "100ms",
-			Median: "50ms",
-			Count:  5,
+		var rtt RoundTripTimes
+		if err := json.Unmarshal([]byte(`{"min": "invalid", "max": "0.100s", "median": "0.050s", "count": 5}`), &rtt); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
 		}
 
-		_, _, _, err := rtt.ConvertToSeconds()
-		if err == nil {
+		if _, ok, err := rtt.Min.Float64(); ok || err == nil {
 			t.Error("Expected error for invalid duration, got none")
 		}
+		if _, ok, err := rtt.Max.Float64(); !ok || err != nil {
+			t.Errorf("Expected the other durations to be valid, got: %v", err)
+		}
 	})
 
 	t.Run("UplinkCount conversion success", func(t *testing.T) {
-		stats := GatewayStats{
-			UplinkCount: "12345",
-		}
-
-		count, err := stats.GetUplinkCount()
-		if err != nil {
+		var stats GatewayStats
+		if err := json.Unmarshal([]byte(`{"uplink_count": "12345"}`), &stats); err != nil {
 			t.Fatalf("Expected no error, got: %v", err)
 		}
 
-		if count != 12345.0 {
+		if count, ok, _ := stats.UplinkCount.Float64(); !ok || count != 12345.0 {
 			t.Errorf("Expected 12345.0, got %f", count)
 		}
 	})
 
 	t.Run("UplinkCount conversion with invalid data", func(t *testing.T) {
-		stats := GatewayStats{
-			UplinkCount: "not-a-number",
+		var stats GatewayStats
+		if err := json.Unmarshal([]byte(`{"uplink_count": "not-a-number"}`), &stats); err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
 		}
 
-		_, err := stats.GetUplinkCount()
-		if err == nil {
+		if _, _, err := stats.UplinkCount.Float64(); err == nil {
 			t.Error("Expected error for invalid number, got none")
 		}
 	})
@@ -412,12 +549,12 @@ func TestEndToEndFlow(t *testing.T) {
 			Protocol:             "udp",
 			LastStatusReceivedAt: time.Now(),
 			LastUplinkReceivedAt: time.Now(),
-			UplinkCount:          fmt.Sprintf("%d", requestCount*100),
+			UplinkCount:          NewUint64String(uint64(requestCount * 100)),
 			RoundTripTimes: RoundTripTimes{
-				Min:    "10ms",
-				Max:    "100ms",
-				Median: "50ms",
-				Count:  requestCount * 10,
+				Min:    NewProtoDuration(10 * time.Millisecond),
+				Max:    NewProtoDuration(100 * time.Millisecond),
+				Median: NewProtoDuration(50 * time.Millisecond),
+				Count:  NewUint64String(uint64(requestCount * 10)),
 			},
 		}
 
@@ -445,23 +582,18 @@ func TestEndToEndFlow(t *testing.T) {
 
 		// Update Prometheus metrics
 		apiCallsTotal.Inc()
-		numberOfDownlinkMessages.WithLabelValues(gatewayId).Set(float64(stats.RoundTripTimes.Count))
-
-		uplinkCount, err := stats.GetUplinkCount()
-		if err != nil {
-			t.Errorf("Failed to get uplink count on iteration %d: %v", i+1, err)
-			continue
-		}
-		numberOfUplinkMessages.WithLabelValues(gatewayId).Set(uplinkCount)
+		numberOfDownlinkMessages.WithLabelValues(gatewayId, "", "eu1", "").Set(float64(stats.DownlinkCount.Value))
+		numberOfUplinkMessages.WithLabelValues(gatewayId, "", "eu1", "").Set(float64(stats.UplinkCount.Value))
 
-		min, median, max, err := stats.RoundTripTimes.ConvertToSeconds()
-		if err != nil {
-			t.Errorf("Failed to convert RTT on iteration %d: %v", i+1, err)
+		rtt := stats.RoundTripTimes
+		if !rtt.Min.Valid || !rtt.Median.Valid || !rtt.Max.Valid {
+			t.Errorf("Missing RTT values on iteration %d: %+v", i+1, rtt)
 			continue
 		}
-		rtt_min.WithLabelValues(gatewayId).Set(min)
-		rtt_median.WithLabelValues(gatewayId).Set(median)
-		rtt_max.WithLabelValues(gatewayId).Set(max)
+		labels := prometheus.Labels{"gateway_id": gatewayId, "gateway_eui": "", "cluster": "eu1", "tenant": ""}
+		setRoundTripTime(labels, "0", rtt.Min.Value.Seconds())
+		setRoundTripTime(labels, "0.5", rtt.Median.Value.Seconds())
+		setRoundTripTime(labels, "1", rtt.Max.Value.Seconds())
 	}
 
 	// Verify metrics endpoint returns data
@@ -484,7 +616,7 @@ func TestEndToEndFlow(t *testing.T) {
 		t.Error("Gateway downlink messages metric not found")
 	}
 
-	if !strings.Contains(body, "gw_rtt_min") {
+	if !strings.Contains(body, `gw_round_trip_time_seconds{cluster="eu1",gateway_eui="",gateway_id="test-gateway",quantile="0",tenant=""}`) {
 		t.Error("Gateway RTT min metric not found")
 	}
 
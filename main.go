@@ -1,52 +1,96 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"syscall"
 	"time"
 
-	"github.com/joho/godotenv"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
 
-	godotenv.Load(".env")
+// serve runs the exporter until it is asked to stop
+func serve(configFile string, config *Config) int {
+	configLastReloadSuccessful.Set(1)
+	configLastReloadSuccess.SetToCurrentTime()
 
-	if !keyExistsInConfig("TTN_GATEWAY_ID") {
-		log.Fatalln("The TTN_GATEWAY_ID is not configured")
+	apiKey, err := checkConfig(config)
+	if err != nil {
+		fatal("Invalid configuration", "error", err)
 	}
+	apiKeyLastLoaded.SetToCurrentTime()
 
-	if !keyExistsInConfig("TTN_API_KEY") {
-		log.Fatalln("The TTN_API_KEY is not configured")
-	}
+	slog.Info("Starting TTN-Gateway-Prometheus-exporter", "version", version, "commit", commit)
 
-	// Read interval
-	intervalInSeconds, err := getEnvInt("READ_INTERVAL", 600)
-	if err != nil {
-		log.Fatalln("READ_INTERVAL is not a number")
+	manager := NewGatewayManager(config.BaseUrl, config.UrlStatsSuffix, apiKey, time.Duration(config.ReadInterval)*time.Second)
+	// The identity server url was validated by checkConfig
+	identityServer, _ := newIdentityServer(config)
+	manager.SetIdentityServer(identityServer)
+	if resolver := newClusterResolver(identityServer, config); resolver != nil {
+		manager.DetectClusters(resolver)
+		slog.Info("Detecting the cluster of the gateways", "identity_server_url", identityServer.url.String())
+	}
+	if config.MetadataTtl > 0 {
+		manager.FetchMetadata(time.Duration(config.MetadataTtl) * time.Second)
+	} else if len(config.AttributeLabels) > 0 {
+		slog.Warn("The attribute labels stay empty because the gateway metadata is disabled, set METADATA_TTL to enable it")
+	}
+	manager.SetLabels(config.Labels, config.AttributeLabels)
+	if config.StrictApiSchema {
+		manager.CheckSchema(NewSchemaChecker())
+	}
+	if config.StreamEvents {
+		manager.StreamEvents()
+	}
+	if config.Transport == transportGrpc {
+		grpcClient := NewGrpcClient(config.GrpcAddress, config.GrpcInsecure)
+		defer grpcClient.Close()
+		manager.UseGrpc(grpcClient)
+		slog.Info("Using the gRPC API of the TTN", "address", config.GrpcAddress)
 	}
 
-	var gatewayId string = os.Getenv("TTN_GATEWAY_ID")
-
-	log.Printf("Starting TTN-Gateway-Prometheus-exporter\n")
-	log.Printf("GatewayID: %s \n", gatewayId)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Get URL
-	var ttnRequestUrl = getEnvString("TTN_BASE_URL", "https://eu1.cloud.thethings.network/api/v3/gs/gateways/")
-	ttnRequestUrl += gatewayId
-	ttnRequestUrl += getEnvString("TTN_URL_STATS_SUFFIX", "/connection/stats")
-	var apiService = NewTTNApiService(ttnRequestUrl, os.Getenv("TTN_API_KEY"))
+	// Validate the rights and expiry of the API key
+	var authInfoChecker *AuthInfoChecker
+	if config.AuthInfoCheckInterval > 0 && apiKey != "" {
+		authInfoChecker = NewAuthInfoChecker(apiRootUrl(config.BaseUrl), apiKey)
+		_, err := authInfoChecker.Check()
+		if errors.Is(err, ErrApiKeyRejected) || errors.Is(err, ErrMissingRights) {
+			fatal("The TTN API key can't be used", "error", err)
+		}
+		if err != nil {
+			slog.Warn("Failed to check the TTN API key", "error", err)
+		}
+		go authInfoChecker.Run(ctx, time.Duration(config.AuthInfoCheckInterval)*time.Second)
+	}
+	// Check a reloaded key right away instead of waiting for the next interval
+	apiKeyChanged := func(key string) {
+		apiKeyLastLoaded.SetToCurrentTime()
+		if authInfoChecker != nil {
+			authInfoChecker.SetApiKey(key)
+			if _, err := authInfoChecker.Check(); err != nil {
+				slog.Error("The reloaded TTN API key check failed", "error", err)
+			}
+		}
+	}
 
 	// HTTP Server
-	var addr = getEnvString("ADDRESS", ":9000")
-	httpService := NewHttpService(addr)
+	httpService := NewHttpService(config.Address)
 
 	// Register the /metrics endpoint
-	var enableRuntimeMetrics, _ = getEnvBool("ENABLE_RUNTIME_METRICS", true)
-	var enableAppMetrics, _ = getEnvBool("ENABLE_APP_METRICS", true)
-	reg := InitPrometheus(enableRuntimeMetrics, enableAppMetrics)
+	legacyMetricNames = config.LegacyMetricNames
+	reg := InitPrometheus(config.EnableRuntimeMetrics, config.EnableAppMetrics, config.CustomLabelNames()...)
 	httpService.RegisterRoute("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
 
 	// You can register more routes here, e.g. health checks
@@ -54,54 +98,90 @@ func main() {
 		w.Write([]byte("ok"))
 	}))
 
-	// Start the HTTP service
-	httpService.Start()
+	// Reload the config file on SIGHUP, on changes and on POST /-/reload
+	var reloader *Reloader
+	if configFile != "" {
+		reloader = NewReloader(configFile, config, manager)
+		reloader.OnApiKeyChange = apiKeyChanged
+	}
 
-	ticker := time.NewTicker(time.Duration(intervalInSeconds) * time.Second)
-	defer ticker.Stop()
+	if config.EnableWebhooks {
+		NewWebhookReceiver(config.WebhookSecretHeader, config.WebhookSecret).Register(httpService)
+		slog.Info("Webhooks enabled", "path", "/webhooks/uplink")
+	}
 
-	// Main loop
-	for range ticker.C {
-		start := time.Now()
+	// Locations of the devices that don't send one with their uplinks
+	deviceLocations.set(config.DeviceLocations)
 
-		response, err := apiService.Get()
-		apiCallsTotal.Inc()
-		log.Println("Getting gateway-statistics")
+	// Count the devices the gateways reach within the window of the uplinks
+	if config.EnableWebhooks || config.EnableMqtt {
+		heardDevices = newDeviceReach(time.Duration(config.DeviceReachWindow) * time.Second)
+		go heardDevices.Run(ctx, deviceReachUpdateInterval)
+	}
 
-		if err != nil {
-			apiCallFailures.Inc()
-			log.Printf("ERROR: Request to the TTN failed: %v", err)
-			continue
-		} else {
-			log.Println(response)
-
-			// Set values in prometheus
-			numberOfDownlinkMessages.WithLabelValues(gatewayId).Set(float64(response.RoundTripTimes.Count))
-			uplinkMessages, err := response.GetUplinkCount()
-
-			if err != nil {
-				log.Printf("WARNING: Failed to parse uplink count: %v", err)
-				continue
-			} else {
-				numberOfUplinkMessages.WithLabelValues(gatewayId).Set(uplinkMessages)
+	if config.EnableMqtt {
+		applications := slices.Clone(config.MqttApplications)
+		for i := range applications {
+			if applications[i].ApiKey == "" {
+				applications[i].ApiKey = config.MqttApiKey
 			}
+		}
+		mqttSubscriber := NewMqttSubscriber(mqttUrl(config), applications)
+		mqttSubscriber.Start()
+		defer mqttSubscriber.Stop()
+		slog.Info("MQTT integration enabled", "url", mqttUrl(config), "applications", len(applications))
+	}
 
-			min, max, median, err := response.RoundTripTimes.ConvertToSeconds()
+	if config.EnableAdminApi {
+		adminApi := NewAdminApi(manager, config.AdminApiToken)
+		if reloader != nil {
+			adminApi.SetReloader(reloader)
+		}
+		adminApi.Register(httpService)
+		slog.Info("Admin API enabled")
+	} else if reloader != nil && config.EnableReloadApi {
+		// Without the admin API there is no token, so the endpoint has to be enabled on its own
+		httpService.RegisterRoute("POST /-/reload", reloader)
+		slog.Info("Reload API enabled without a token")
+	}
 
-			if err != nil {
-				log.Printf("WARNING: Failed to parse rtt values: %v", err)
-				continue
-			} else {
-				numberOfUplinkMessages.WithLabelValues(gatewayId).Set(uplinkMessages)
-			}
+	// TLS and basic auth
+	httpService.SetWebConfigFile(config.WebConfigFile)
 
-			rtt_min.WithLabelValues(gatewayId).Set(min)
-			rtt_median.WithLabelValues(gatewayId).Set(median)
-			rtt_max.WithLabelValues(gatewayId).Set(float64(max))
+	// Start the HTTP service
+	if err := httpService.Start(); err != nil {
+		fatal("Failed to start HTTP server", "error", err)
+	}
+
+	for _, gateway := range config.Gateways {
+		if err := manager.Add(gateway); err != nil {
+			slog.Warn("Skipping gateway", "gateway", gateway.name(), "error", err)
 		}
+	}
+
+	// Only persist runtime changes, the initial gateways are already configured
+	if config.PersistGatewayChanges && configFile != "" {
+		manager.PersistTo(configFile)
+	}
 
-		duration := time.Since(start).Seconds()
-		log.Printf("Done (Last request duration: %.5fs) \n", duration)
-		lastApiCallDuration.Set(duration)
+	// Pick up rotated keys without a restart
+	if config.ApiKeyFile != "" {
+		go WatchApiKeyFile(ctx, config.ApiKeyFile, apiKey, 10*time.Second, func(key string) {
+			manager.SetApiKey(key)
+			apiKeyChanged(key)
+			slog.Info("Reloaded the TTN api key", "path", config.ApiKeyFile)
+		})
+	}
+	go manager.WatchApiKeyFiles(ctx, 10*time.Second)
+	if reloader != nil {
+		go reloader.WatchSignal(ctx)
+		go reloader.WatchFile(ctx, 10*time.Second)
 	}
+
+	// Run until the process is asked to stop
+	<-ctx.Done()
+
+	slog.Info("Shutting down")
+	manager.StopAll()
+	return 0
 }
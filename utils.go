@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 )
 
 func keyExistsInConfig(name string) bool {
@@ -42,3 +43,21 @@ func getEnvString(name string, defaultVal string) string {
 	}
 	return val
 }
+
+// getEnvMap parses a comma separated list of name=value pairs
+func getEnvMap(name string, defaultVal map[string]string) (map[string]string, error) {
+	val := os.Getenv(name)
+	if val == "" {
+		return defaultVal, nil
+	}
+	m := make(map[string]string)
+	for _, pair := range strings.Split(val, ",") {
+		key, value, found := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			return nil, fmt.Errorf("invalid %s: %q is not a name=value pair", name, strings.TrimSpace(pair))
+		}
+		m[key] = strings.TrimSpace(value)
+	}
+	return m, nil
+}
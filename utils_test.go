@@ -116,3 +116,30 @@ func TestGetEnvString(t *testing.T) {
 		assert.Equal(t, result, "world")
 	})
 }
+
+func TestGetEnvMap(t *testing.T) {
+	const testKey = "MAP_ENV_VAR"
+
+	// Clean up environment after test
+	defer os.Unsetenv(testKey)
+
+	t.Run("Key does not exist", func(t *testing.T) {
+		os.Unsetenv(testKey)
+		result, err := getEnvMap(testKey, map[string]string{"site": "berlin"})
+		assert.Equal(t, map[string]string{"site": "berlin"}, result)
+		assert.Nil(t, err)
+	})
+
+	t.Run("Key exists with pairs", func(t *testing.T) {
+		os.Setenv(testKey, "site=berlin, customer = acme,empty=")
+		result, err := getEnvMap(testKey, nil)
+		assert.Equal(t, map[string]string{"site": "berlin", "customer": "acme", "empty": ""}, result)
+		assert.Nil(t, err)
+	})
+
+	t.Run("Key has no pairs", func(t *testing.T) {
+		os.Setenv(testKey, "site=berlin,acme")
+		_, err := getEnvMap(testKey, nil)
+		assert.EqualError(t, err, "invalid MAP_ENV_VAR: \"acme\" is not a name=value pair")
+	})
+}